@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"flag"
+	"os"
+	"os/signal"
 	"time"
 
-	"github.com/rotationalio/go-ensign"
-	api "github.com/rotationalio/go-ensign/api/v1beta1"
-	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/pipeline"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
 )
 
 // Hey there! Here's where you write your code
@@ -20,91 +26,73 @@ const MyCoolEnsignTopic = "otters-are-the-best"
 type YourCustomStruct struct {
 }
 
-// Consume is a helper function that takes as input a event chan that gets created by calling sub.Subscribe()
-// and ranges over any events that it receives on the chan, unmarshals them, and prints them out
-func Consume(events <-chan *ensign.Event) {
-	for e := range events {
-		customStruct := &YourCustomStruct{}
-		if err := json.Unmarshal(e.Data, &customStruct); err != nil {
-			panic("unable to unmarshal event: " + err.Error())
-		}
-		fmt.Printf("message received! \n %v", customStruct)
+// Hey there! Give your custom struct an event type name and version here
+// Bump the minor version when you add fields and the major version when you remove or change them,
+// so subscribers running older code will nack the events they can't read instead of misreading them
+var YourCustomEvent = types.MustRegister("YourCustomStruct", "1.0.0", YourCustomStruct{}, "")
 
-		// Let ensign know the event has been processed correctly so you get the next
-		// event in the topic!
-		e.Ack()
-	}
+// Fetch is called once per tick to get the next packet from your streaming data source
+func Fetch(ctx context.Context) (*YourCustomStruct, error) {
+	myPacket := &YourCustomStruct{}
+
+	// Hey there! Here's where you write your code
+	// to get data from your streaming source and unmarshal it into your custom struct!
+	return myPacket, nil
+}
+
+// Consume is called with each event received from the topic, already unmarshaled into
+// your custom struct. Returning nil acks the event so you get the next event in the
+// topic; returning an error (or panicking) retries the event a few times and then moves
+// it to the dead letter topic so one bad event doesn't stop the rest.
+// The logger from the context adds the topic, event ID and event type to every line.
+func Consume(ctx context.Context, customStruct *YourCustomStruct) error {
+	logger.FromContext(ctx).Info("message received!", "message", customStruct)
+	return nil
 }
 
 func main() {
+	// Pick the credentials with -credentials path/to/key.json or -profile dev
+	creds := config.RegisterFlags(flag.CommandLine)
+	// Pick the log output with -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	logs := logger.RegisterFlags(flag.CommandLine)
+	// Serve Prometheus metrics with -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	prom := metrics.RegisterFlags(flag.CommandLine)
+	// Export a trace of every event with -trace-exporter otlp (or file) or $ENSIGN_TRACE_EXPORTER
+	traces := tracing.RegisterFlags(flag.CommandLine)
+	interval := flag.Duration("interval", time.Second, "how often to fetch from your streaming source")
+	flag.Parse()
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("boilerplate")()
 
 	// Create Ensign Client
-	client, err := ensign.New() // if your credentials are already in your bash profile, you don't have to pass anything into New()
-	// client, err := ensign.New(ensign.WithCredentials("YOUR CLIENT ID HERE!", "YOUR CLIENT SECRET HERE!"))
+	client, err := creds.Client() // if your credentials are already in your bash profile, you don't have to pass any flags
 	if err != nil {
-		panic(fmt.Errorf("could not create client: %s", err))
-	}
-
-	// Check to see if topic exists, if it does then the variable exists will be True
-	exists, err := client.TopicExists(context.Background(), MyCoolEnsignTopic)
-	if err != nil {
-		panic(fmt.Errorf("unable to check topic existence: %s", err))
-	}
-
-	// If the topic does not exist, create it using the CreateTopic method
-	if !exists {
-		if _, err = client.CreateTopic(context.Background(), MyCoolEnsignTopic); err != nil {
-			panic(fmt.Errorf("unable to create topic: %s", err))
-		}
+		logger.Fatal("could not create client", err)
 	}
-
-	// Hey there! Here's where you write your code
-	// to get data from your streaming source!
-
-	// Create a subscriber  - the same subscriber should be consuming each event that comes down the pipe
-	sub, err := client.Subscribe(MyCoolEnsignTopic) // topic alias also works
-	if err != nil {
-		fmt.Printf("could not create subscriber: %s", err)
+	defer client.Close()
+
+	// Stop publishing and consuming when ctrl-c is pressed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Events that still fail after retrying go to the otters-are-the-best-dlq topic, once
+	// you've fixed Consume send them back with the redrive command in go/shared/cmd/examples
+	dlq := &deadletter.Consumer{Client: client, Topic: MyCoolEnsignTopic}
+
+	// The pipeline creates the topic if it doesn't exist, publishes each packet from
+	// Fetch and has the subscriber pass every event it receives to Consume
+	p := &pipeline.Pipeline[*YourCustomStruct]{
+		Client:     client,
+		Topic:      MyCoolEnsignTopic,
+		Source:     pipeline.Ticker(*interval, Fetch),
+		Handler:    Consume,
+		Middleware: []mux.Middleware{dlq.Middleware, mux.Recovery()},
+		Metrics:    stats,
 	}
 
-	// Loop over each response that is returned by your streaming source, publish it to the topicID, have the subscriber consume to the events channel
-	for {
-		myPacket := &YourCustomStruct{}
-
-		// Hey there! Here's where you write your code
-		// Unmarshal each response you get from your streaming source into your custom struct
-
-		// Put that unmarshaled data into an Ensign Event struct
-		e := &ensign.Event{
-			Mimetype: mimetype.ApplicationJSON,
-			Type: &api.Type{
-				Name:         "Generic",
-				MajorVersion: 1,
-				MinorVersion: 0,
-				PatchVersion: 0,
-			},
-		}
-
-		// Remarshal the Ensign Event so it's ready to publish!
-		if e.Data, err = json.Marshal(myPacket); err != nil {
-			panic("could not marshal data to JSON: " + err.Error())
-		}
-
-		// Publish the newly received tick event to the Topic
-		fmt.Printf("Publishing to topic: %s\n", MyCoolEnsignTopic)
-		time.Sleep(1 * time.Second)
-
-		// On publish, the client checks to see if it has an open publish stream created
-		// and if it doesn't it opens a stream to the correct Ensign node.
-		// Topic alias also works
-		if err = client.Publish(MyCoolEnsignTopic, e); err != nil {
-			panic("could not publish event: " + err.Error())
-		}
-		// client.Publish(topicID, e, a, f, h) // Can publish a couple events if you want!
-		// client.Publish(differentTopicId, e) // or, if you Publish to a second, valid topicID, the Ensign client will create another new Publisher!
-
-		// Goroutine to check the events channel to ensure that subscriber is getting all the events!
-		time.Sleep(1 * time.Second)
-		go Consume(sub.C)
+	log.Info("publishing to topic", logger.KeyTopic, MyCoolEnsignTopic)
+	if err = p.Run(ctx); err != nil {
+		logger.Fatal("pipeline stopped", err, logger.KeyTopic, MyCoolEnsignTopic)
 	}
 }
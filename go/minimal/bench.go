@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Metadata keys used to match benchmark events on the subscription
+const (
+	benchRun  = "bench_run"
+	benchSent = "bench_sent"
+)
+
+// Bench publishes N MessageInABottle events with a configurable payload size at a target
+// rate and matches them on its own subscription, reporting throughput, publish to
+// receive latency percentiles and loss so that workloads can be sized before building
+// on Ensign.
+func Bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	topic := fs.String("topic", CocoaBeans, "topic to publish the benchmark events to")
+	n := fs.Int("n", 1000, "total number of events to publish")
+	size := fs.Int("size", 256, "size in bytes of the message in each event")
+	rate := fs.Float64("rate", 100, "target events per second across all publishers (0 for as fast as possible)")
+	publishers := fs.Int("publishers", 1, "number of concurrent publishers")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for outstanding events after publishing")
+	format := fs.String("codec", "json", "payload encoding: "+strings.Join(codec.Default.Names(), ", "))
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)
+	traces := tracing.RegisterFlags(fs)
+	fs.Parse(args)
+	logs.Setup()
+
+	switch {
+	case *n < 1:
+		logger.Fatal("invalid benchmark", errors.New("publish at least one event with -n"), "n", *n)
+	case *size < 0:
+		logger.Fatal("invalid benchmark", errors.New("-size cannot be negative"), "size", *size)
+	case *rate < 0:
+		logger.Fatal("invalid benchmark", errors.New("-rate cannot be negative"), "rate", *rate)
+	case *publishers < 1:
+		logger.Fatal("invalid benchmark", errors.New("specify at least one publisher with -publishers"), "publishers", *publishers)
+	}
+
+	stats := prom.Setup()
+	defer traces.Setup("minimal-bench")()
+
+	enc, err := codec.Named(*format)
+	if err != nil {
+		logger.Fatal("could not use codec", err)
+	}
+	if enc == codec.Protobuf {
+		logger.Fatal("could not use codec", errors.New("the benchmark payload is not a protocol buffer message, choose another codec"))
+	}
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	defer client.Close()
+
+	EnsureTopic(client, *topic)
+
+	// Subscribe before publishing so that none of the benchmark events are missed
+	sub, err := client.Subscribe(*topic)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, *topic)
+	}
+	defer sub.Close()
+
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	message := strings.Repeat("~", *size)
+
+	// Each publisher sends its share of the events at its share of the rate
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		published int
+		failed    int
+	)
+
+	start := time.Now()
+	for p := 0; p < *publishers; p++ {
+		count := *n / *publishers
+		if p < *n%*publishers {
+			count++
+		}
+
+		wg.Add(1)
+		go func(p, count int) {
+			defer wg.Done()
+
+			var ticker *time.Ticker
+			if *rate > 0 {
+				// Rates faster than the clock resolution tick as fast as possible
+				interval := time.Duration(float64(time.Second) * float64(*publishers) / *rate)
+				if interval < time.Nanosecond {
+					interval = time.Nanosecond
+				}
+				ticker = time.NewTicker(interval)
+				defer ticker.Stop()
+			}
+
+			for i := 0; i < count; i++ {
+				if ticker != nil {
+					<-ticker.C
+				}
+
+				e := &ensign.Event{Metadata: ensign.Metadata{benchRun: run}}
+
+				var err error
+				if err = MessageInABottleType.Encode(enc, e, MessageInABottle{Sender: fmt.Sprintf("publisher-%d", p), Message: message, Timestamp: time.Now().String()}); err != nil {
+					logger.Fatal("could not encode benchmark event", err)
+				}
+
+				e.Metadata.Set(benchSent, strconv.FormatInt(time.Now().UnixNano(), 10))
+				err = publish(context.Background(), client, stats, *topic, e)
+
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					published++
+				}
+				mu.Unlock()
+			}
+		}(p, count)
+	}
+
+	// Receive events until every published event has arrived or the timeout expires
+	latencies := make([]time.Duration, 0, *n)
+	done := make(chan struct{})
+	go func(done chan<- struct{}) {
+		wg.Wait()
+		close(done)
+	}(done)
+
+	var deadline <-chan time.Time
+	var last time.Time
+receive:
+	for {
+		select {
+		case <-done:
+			done = nil
+			deadline = time.After(*timeout)
+		case <-deadline:
+			break receive
+		case event, ok := <-sub.C:
+			if !ok {
+				slog.Warn("subscription closed before every benchmark event was received")
+				break receive
+			}
+
+			_, span := tracing.StartConsume(context.Background(), *topic, event)
+			stats.Received(*topic, event)
+			stats.Ack(*topic, event)
+			span.End()
+
+			if event.Metadata.Get(benchRun) != run {
+				continue
+			}
+
+			last = time.Now()
+			if sent, err := strconv.ParseInt(event.Metadata.Get(benchSent), 10, 64); err == nil {
+				latencies = append(latencies, last.Sub(time.Unix(0, sent)))
+			}
+
+			mu.Lock()
+			complete := done == nil && len(latencies) >= published
+			mu.Unlock()
+			if complete {
+				break receive
+			}
+		}
+	}
+
+	elapsed := last.Sub(start)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(q float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+
+	fmt.Println("=== benchmark results ===")
+	fmt.Printf("publishers:  %d\n", *publishers)
+	fmt.Printf("payload:     %d bytes (%s)\n", *size, enc.Name())
+	fmt.Printf("published:   %d (%d failed)\n", published, failed)
+	fmt.Printf("received:    %d\n", len(latencies))
+	if published > 0 {
+		fmt.Printf("loss:        %d (%.2f%%)\n", published-len(latencies), 100*float64(published-len(latencies))/float64(published))
+	}
+	if elapsed > 0 {
+		fmt.Printf("throughput:  %.1f events/sec\n", float64(len(latencies))/elapsed.Seconds())
+	}
+	fmt.Printf("latency p50: %s\n", percentile(0.50))
+	fmt.Printf("latency p95: %s\n", percentile(0.95))
+	fmt.Printf("latency p99: %s\n", percentile(0.99))
+	fmt.Printf("latency max: %s\n", percentile(1))
+
+	if published-len(latencies) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Metadata keys used by the chat so that members can recognize their own echoes and
+// answer history requests from members that have just joined the room.
+const (
+	chatSession = "chat_session"
+	chatReplyTo = "chat_reply_to"
+)
+
+// Event types published to the room topic
+var (
+	ChatMessageType        = types.MustRegister("ChatMessage", "1.0.0", MessageInABottle{}, "")
+	ChatHistoryRequestType = types.MustRegister("ChatHistoryRequest", "1.0.0", struct{}{}, "")
+	ChatHistoryType        = types.MustRegister("ChatHistory", "1.0.0", []*ChatRecord{}, "")
+)
+
+// ChatRecord is a message in the room along with its unique ID so that history sent by
+// several members of the room can be deduplicated.
+type ChatRecord struct {
+	ID string `json:"id"`
+	MessageInABottle
+}
+
+// ChatRoom keeps the last N messages seen in the room. Ensign subscriptions only
+// deliver new events, so members that join late ask the rest of the room for the
+// messages they missed and whoever is listening answers from their own history.
+type ChatRoom struct {
+	topic   string
+	name    string
+	session string
+	size    int
+	client  *ensign.Client
+	stats   *metrics.Metrics
+	history []*ChatRecord
+	joined  bool
+}
+
+// Chat is an interactive terminal chat: every member of the room subscribes to the room
+// topic and publishes the lines typed on stdin as MessageInABottle events, so running
+// it in several terminals is a quick manual test of multi-subscriber fan-out.
+func Chat(args []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	room := fs.String("room", "lobby", "name of the room to join; each room is its own topic")
+	name := fs.String("name", os.Getenv("USER"), "name to send messages as")
+	history := fs.Int("history", 10, "number of previous messages to show on join (0 to disable)")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)
+	traces := tracing.RegisterFlags(fs)
+	fs.Parse(args)
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("minimal-chat")()
+
+	if *name == "" {
+		*name = "Anonymous Otter"
+	}
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	defer client.Close()
+
+	chat := &ChatRoom{
+		topic:   "chat-" + strings.ToLower(*room),
+		name:    *name,
+		session: ulid.Make().String(),
+		size:    *history,
+		client:  client,
+		stats:   stats,
+	}
+	EnsureTopic(client, chat.topic)
+
+	sub, err := client.Subscribe(chat.topic)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, chat.topic)
+	}
+	defer sub.Close()
+
+	// Ask the members already in the room for the messages we missed
+	if chat.size > 0 {
+		if err = chat.publish(context.Background(), ChatHistoryRequestType, nil, struct{}{}); err != nil {
+			log.Warn("could not request room history", logger.KeyTopic, chat.topic, "error", err)
+		}
+	} else {
+		chat.joined = true
+	}
+
+	events := chat.Mux()
+	fmt.Printf("joined room %q as %s (type /quit or ctrl-d to leave)\n", *room, chat.name)
+
+	// Read lines from the terminal in the background so that incoming messages are
+	// printed while we wait for the user to type.
+	lines := make(chan string)
+	go func(lines chan<- string) {
+		defer close(lines)
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}(lines)
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok || strings.TrimSpace(line) == "/quit" {
+				return
+			}
+
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			if err := chat.Send(line); err != nil {
+				log.Error("could not send message", logger.KeyTopic, chat.topic, "error", err)
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				log.Info("subscription closed", logger.KeyTopic, chat.topic)
+				return
+			}
+			// Every event is acked, the room is best effort and has no one to redeliver
+			// to; handler errors are logged by the mux
+			stats.Received(chat.topic, event)
+			events.Dispatch(context.Background(), event)
+			stats.Ack(chat.topic, event)
+		}
+	}
+}
+
+// Send publishes a message to the room and records it in our own history, since our
+// own echo is suppressed when it comes back on the subscription.
+func (c *ChatRoom) Send(message string) error {
+	record := &ChatRecord{
+		ID: ulid.Make().String(),
+		MessageInABottle: MessageInABottle{
+			Sender:    c.name,
+			Message:   message,
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}
+
+	if err := c.publish(context.Background(), ChatMessageType, ensign.Metadata{MessageID: record.ID}, record.MessageInABottle); err != nil {
+		return err
+	}
+	c.remember(record)
+	return nil
+}
+
+// Mux returns the event mux that handles each event type published to the room
+func (c *ChatRoom) Mux() *mux.Mux {
+	events := mux.New()
+	events.Use(mux.Logging(nil, c.topic), tracing.Middleware(c.topic), c.skipEchoes)
+	events.HandleType(ChatMessageType, mux.Decoded(ChatMessageType, c.handleMessage))
+	events.HandleType(ChatHistoryRequestType, c.handleHistoryRequest)
+	events.HandleType(ChatHistoryType, mux.Decoded(ChatHistoryType, c.handleHistory))
+
+	// Ignore events published to the room by newer or older versions of the chat
+	events.Fallback(func(context.Context, *ensign.Event) error { return nil })
+	return events
+}
+
+// skipEchoes suppresses the echoes of everything we published ourselves
+func (c *ChatRoom) skipEchoes(next mux.HandlerFunc) mux.HandlerFunc {
+	return func(ctx context.Context, event *ensign.Event) error {
+		if event.Metadata.Get(chatSession) == c.session {
+			return nil
+		}
+		return next(ctx, event)
+	}
+}
+
+func (c *ChatRoom) handleMessage(ctx context.Context, event *ensign.Event, msg MessageInABottle) error {
+	record := &ChatRecord{ID: event.Metadata.Get(MessageID), MessageInABottle: msg}
+	c.remember(record)
+	c.print(record)
+	return nil
+}
+
+// handleHistoryRequest answers requests from new members with whatever history we have
+func (c *ChatRoom) handleHistoryRequest(ctx context.Context, event *ensign.Event) error {
+	if len(c.history) > 0 {
+		if err := c.publish(ctx, ChatHistoryType, ensign.Metadata{chatReplyTo: event.Metadata.Get(chatSession)}, c.history); err != nil {
+			return fmt.Errorf("could not send room history: %w", err)
+		}
+	}
+	return nil
+}
+
+// handleHistory shows the first answer to our own history request, the rest are duplicates
+func (c *ChatRoom) handleHistory(ctx context.Context, event *ensign.Event, records []*ChatRecord) error {
+	if c.joined || event.Metadata.Get(chatReplyTo) != c.session {
+		return nil
+	}
+
+	for _, record := range records {
+		c.remember(record)
+	}
+
+	c.joined = true
+	fmt.Printf("--- last %d messages ---\n", len(c.history))
+	for _, record := range c.history {
+		c.print(record)
+	}
+	fmt.Println("---")
+	return nil
+}
+
+// remember adds the record to the history, skipping duplicates and keeping only the
+// most recent messages ordered by timestamp.
+func (c *ChatRoom) remember(record *ChatRecord) {
+	if c.size <= 0 {
+		return
+	}
+
+	for _, seen := range c.history {
+		if seen.ID == record.ID {
+			return
+		}
+	}
+
+	c.history = append(c.history, record)
+	sort.SliceStable(c.history, func(i, j int) bool { return c.history[i].before(c.history[j]) })
+	if len(c.history) > c.size {
+		c.history = c.history[len(c.history)-c.size:]
+	}
+}
+
+// before compares the records by time rather than by timestamp string since peers may
+// stamp messages in different zones or without fractional seconds. Timestamps that
+// can't be parsed fall back to comparing the strings.
+func (r *ChatRecord) before(other *ChatRecord) bool {
+	ts, err := time.Parse(time.RFC3339Nano, r.Timestamp)
+	if err != nil {
+		return r.Timestamp < other.Timestamp
+	}
+
+	ots, err := time.Parse(time.RFC3339Nano, other.Timestamp)
+	if err != nil {
+		return r.Timestamp < other.Timestamp
+	}
+	return ts.Before(ots)
+}
+
+func (c *ChatRoom) print(record *ChatRecord) {
+	ts := record.Timestamp
+	if parsed, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+		ts = parsed.Local().Format("15:04:05")
+	}
+	fmt.Printf("[%s] %s: %s\n", ts, record.Sender, record.Message)
+}
+
+// publish the value to the room in the trace of the context, e.g. the trace of the
+// history request that is being answered
+func (c *ChatRoom) publish(ctx context.Context, eventType *types.EventType, meta ensign.Metadata, v interface{}) (err error) {
+	if meta == nil {
+		meta = make(ensign.Metadata)
+	}
+	meta.Set(chatSession, c.session)
+
+	e := &ensign.Event{Metadata: meta}
+	if err = eventType.Encode(codec.JSON, e, v); err != nil {
+		return err
+	}
+	return publish(ctx, c.client, c.stats, c.topic, e)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Check is the result of a single doctor step
+type Check struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	Hint     string        `json:"hint,omitempty"`
+	ExitCode int           `json:"-"`
+}
+
+// step is one of the diagnostic checks that the doctor runs in order; each step gets
+// the state from the previous steps so that it can build on them.
+type step struct {
+	name     string
+	hint     string
+	exitCode int
+	run      func(ctx context.Context, d *doctor) error
+}
+
+type doctor struct {
+	topic   string
+	timeout time.Duration
+	flags   *config.Flags
+	creds   *config.Credentials
+	client  *ensign.Client
+	sub     *ensign.Subscription
+	stats   *metrics.Metrics
+	id      string
+}
+
+// The steps are run in order and the doctor stops at the first failure, since each
+// step depends on the one before it. The exit code identifies the failing step.
+var steps = []step{
+	{
+		name:     "credentials found",
+		hint:     "save the API key you downloaded from https://rotational.app as ~/.ensign/<profile>.json (chmod 600) and pass -profile, or export ENSIGN_CLIENT_ID and ENSIGN_CLIENT_SECRET",
+		exitCode: 10,
+		run: func(ctx context.Context, d *doctor) (err error) {
+			d.creds, err = d.flags.Load()
+			return err
+		},
+	},
+	{
+		name:     "auth token obtained",
+		hint:     "check that your API key has not been revoked and that the client ID and secret were copied correctly",
+		exitCode: 11,
+		run: func(ctx context.Context, d *doctor) (err error) {
+			if d.client, err = ensign.New(d.creds.Options()...); err != nil {
+				return err
+			}
+
+			_, err = d.client.QuarterdeckClient().Login(ctx, d.creds.ClientID, d.creds.ClientSecret)
+			return err
+		},
+	},
+	{
+		name:     "topic exists or is created",
+		hint:     "make sure your API key has the topics:read and topics:create permissions",
+		exitCode: 12,
+		run: func(ctx context.Context, d *doctor) error {
+			exists, err := d.client.TopicExists(ctx, d.topic)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				_, err = d.client.CreateTopic(ctx, d.topic)
+			}
+			return err
+		},
+	},
+	{
+		name:     "subscribe works",
+		hint:     "make sure your API key has the subscriber permission and that outbound gRPC (port 443) is not blocked",
+		exitCode: 13,
+		run: func(ctx context.Context, d *doctor) (err error) {
+			// Subscribe before publishing so that the round trip event isn't missed
+			d.sub, err = d.client.Subscribe(d.topic)
+			return err
+		},
+	},
+	{
+		name:     "publish works",
+		hint:     "make sure your API key has the publisher permission for this project",
+		exitCode: 14,
+		run: func(ctx context.Context, d *doctor) (err error) {
+			e := &ensign.Event{Metadata: ensign.Metadata{MessageID: d.id}}
+			if err = MessageInABottleType.Encode(codec.JSON, e, MessageInABottle{Sender: "Ensign Doctor", Message: "ping", Timestamp: time.Now().String()}); err != nil {
+				return err
+			}
+
+			if err = publish(ctx, d.client, d.stats, d.topic, e); err != nil {
+				return err
+			}
+
+			// The publish is asynchronous so wait for the server to ack the event
+			return WaitForAck(ctx, e)
+		},
+	},
+	{
+		name:     "round trip completes",
+		hint:     "the event was published but not received; check the Ensign status page and try again with a longer -timeout",
+		exitCode: 15,
+		run: func(ctx context.Context, d *doctor) error {
+			_, err := WaitForMessage(d.sub, d.stats, d.topic, d.id, d.timeout)
+			return err
+		},
+	},
+}
+
+// WaitForAck polls the published event until the server acks or nacks it
+func WaitForAck(ctx context.Context, e *ensign.Event) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if acked, err := e.Acked(); acked || err != nil {
+			return err
+		}
+
+		if nacked, err := e.Nacked(); nacked {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("publish was not acked: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Doctor runs each connectivity check in turn, reporting pass/fail, timing and a
+// remediation hint for each step. The process exits with 0 if every step passed or
+// the exit code of the first step that failed.
+func Doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	topic := fs.String("topic", CocoaBeans, "topic to use for the publish and subscribe checks")
+	timeout := fs.Duration("timeout", 30*time.Second, "deadline for each step")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	flags := config.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs) // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	fs.Parse(args)
+
+	d := &doctor{
+		topic:   *topic,
+		timeout: *timeout,
+		flags:   flags,
+		stats:   prom.Setup(),
+		id:      ulid.Make().String(),
+	}
+
+	checks := make([]*Check, 0, len(steps))
+	exitCode := 0
+	for _, s := range steps {
+		check := &Check{Name: s.name, ExitCode: s.exitCode}
+		checks = append(checks, check)
+
+		// Skip the remaining steps once one has failed since they depend on it
+		if exitCode != 0 {
+			check.Skipped = true
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		start := time.Now()
+		err := s.run(ctx, d)
+		check.Duration = time.Since(start)
+		cancel()
+
+		if err != nil {
+			check.Error = err.Error()
+			check.Hint = s.hint
+			exitCode = s.exitCode
+			continue
+		}
+		check.Passed = true
+	}
+
+	if d.sub != nil {
+		d.sub.Close()
+	}
+	if d.client != nil {
+		d.client.Close()
+	}
+
+	var source string
+	if d.creds != nil {
+		source = d.creds.Source
+	}
+
+	if *asJSON {
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		out.Encode(map[string]interface{}{"passed": exitCode == 0, "exit_code": exitCode, "credentials": source, "checks": checks})
+	} else {
+		if source != "" {
+			fmt.Printf("using Ensign credentials from %s\n", source)
+		}
+		for _, check := range checks {
+			switch {
+			case check.Skipped:
+				fmt.Printf("[SKIP] %s\n", check.Name)
+			case check.Passed:
+				fmt.Printf("[PASS] %s (%s)\n", check.Name, check.Duration.Round(time.Millisecond))
+			default:
+				fmt.Printf("[FAIL] %s (%s)\n       error: %s\n       hint:  %s\n", check.Name, check.Duration.Round(time.Millisecond), check.Error, check.Hint)
+			}
+		}
+	}
+
+	os.Exit(exitCode)
+}
@@ -2,13 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 	"time"
 
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
 	ensign "github.com/rotationalio/go-ensign"
-	api "github.com/rotationalio/go-ensign/api/v1beta1"
-	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
 )
 
 type MessageInABottle struct {
@@ -17,26 +25,78 @@ type MessageInABottle struct {
 	Timestamp string `json:"timestamp,omitempty"`
 }
 
+// MessageInABottleType is stamped on the messages published by the round trip, the
+// doctor and the benchmark
+var MessageInABottleType = types.MustRegister("MessageInABottle", "1.0.0", MessageInABottle{}, "")
+
 const CocoaBeans = "chocolate-covered-espresso-beans"
 
+// MessageID is the metadata key that tags each run's event with a unique ID so that the
+// subscriber can tell its own message apart from stale ones already in the topic.
+const MessageID = "message_id"
+
+// Commands are the additional modes that can be run by passing the name of the mode as
+// the first argument, e.g. go run . doctor (add -json for machine readable output)
+var commands = map[string]func(args []string){
+	"doctor": Doctor,
+	"bench":  Bench,
+	"chat":   Chat,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	// Fail after the deadline rather than waiting forever so CI smoke tests don't hang
+	timeout := flag.Duration("timeout", 30*time.Second, "how long to wait for the message to make the round trip")
+
+	// Smoke tests can use a uniquely named topic per run that is cleaned up afterward
+	ephemeral := flag.Bool("ephemeral", false, "publish to a new uniquely named topic for this run")
+	cleanup := flag.String("cleanup", "destroy", "what to do with the ephemeral topic after the run passes: archive or destroy")
+	sweep := flag.Duration("sweep", 24*time.Hour, "clean up ephemeral topics from earlier runs older than this (0 to disable)")
+	creds := config.RegisterFlags(flag.CommandLine)
+	logs := logger.RegisterFlags(flag.CommandLine)    // -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	prom := metrics.RegisterFlags(flag.CommandLine)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(flag.CommandLine) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	flag.Parse()
+	log := logs.Setup()
+
+	// Catch a bad -cleanup before the run rather than after the topic has been created
+	if err := CheckCleanup(*cleanup); err != nil {
+		logger.Fatal("invalid cleanup mode", err, "cleanup", *cleanup)
+	}
+
+	stats := prom.Setup()
+	defer traces.Setup("minimal")()
+
 	// Create Ensign Client
-	client, err := ensign.New() // if your credentials are already in your bash profile, you don't have to pass anything into New()
-	// client, err := ensign.New(ensign.WithCredentials("YOUR CLIENT ID HERE!", "YOUR CLIENT SECRET HERE!"))
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
 	if err != nil {
-		panic(fmt.Errorf("could not create client: %s", err))
+		logger.Fatal("could not create client", err)
 	}
 
 	// Check to see if topic exists and create it if not
-	exists, err := client.TopicExists(context.Background(), CocoaBeans)
-	if err != nil {
-		panic(fmt.Errorf("unable to check topic existence: %s", err))
-	}
+	topic := CocoaBeans
+	var topicID string
+	if *ephemeral {
+		// Sweep up topics left behind by earlier runs that failed or were interrupted
+		if *sweep > 0 {
+			if _, err = SweepTopics(client, *sweep, *cleanup); err != nil {
+				log.Warn("could not sweep stale topics", "error", err)
+			}
+		}
 
-	if !exists {
-		if _, err = client.CreateTopic(context.Background(), CocoaBeans); err != nil {
-			panic(fmt.Errorf("unable to create topic: %s", err))
+		topic = EphemeralTopic()
+		if topicID, err = client.CreateTopic(context.Background(), topic); err != nil {
+			logger.Fatal("unable to create topic", err, logger.KeyTopic, topic)
 		}
+		log.Info("created ephemeral topic", logger.KeyTopic, topic)
+	} else {
+		EnsureTopic(client, topic)
 	}
 
 	// Prep event data and marshal for transmission
@@ -45,48 +105,102 @@ func main() {
 		Timestamp: time.Now().String(),
 		Message:   "You're looking smart today!",
 	}
-	// Put that unmarshaled data into an Ensign Event struct
-	e := &ensign.Event{
-		Mimetype: mimetype.ApplicationJSON,
-		Type: &api.Type{
-			Name:         "Generic",
-			MajorVersion: 1,
-			MinorVersion: 0,
-			PatchVersion: 0,
-		},
-	}
-	// Remarshal the Ensign Event so it's ready to publish!
-	if e.Data, err = json.Marshal(data); err != nil {
-		panic("could not marshal data to JSON: " + err.Error())
+	// Put that unmarshaled data into an Ensign Event struct, tagged with a unique ID
+	id := ulid.Make().String()
+	e := &ensign.Event{Metadata: ensign.Metadata{MessageID: id}}
+
+	// Encode the data as JSON (which also sets the event mimetype and type) so it's ready to publish!
+	if err = MessageInABottleType.Encode(codec.JSON, e, data); err != nil {
+		logger.Fatal("could not encode message", err)
 	}
 
 	// Create a subscriber  - the same subscriber should be consuming each event that comes down the pipe
-	sub, err := client.Subscribe(CocoaBeans) // topic alias also works
+	sub, err := client.Subscribe(topic) // topic alias also works
 	if err != nil {
-		panic(fmt.Errorf("could not create subscriber: %s", err))
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, topic)
 	}
 
-	fmt.Printf("Publishing to topic: %s\n", CocoaBeans)
+	log.Info("publishing to topic", logger.KeyTopic, topic, MessageID, id)
 	time.Sleep(1 * time.Second)
 
 	// Publish the message in a bottle after waiting for a second
 	// On publish, the client checks to see if it has an open publish stream created
 	// and if it doesn't it opens a stream to the correct Ensign node.
 	// Topic alias also works
-	if err = client.Publish(CocoaBeans, e); err != nil {
-		panic(fmt.Errorf("could not publish event: %s", err))
+	if err = publish(context.Background(), client, stats, topic, e); err != nil {
+		logger.Fatal("could not publish event", err, logger.KeyTopic, topic)
 	}
 	// client.Publish(topicID, e, a, f, h) // Can publish a couple events if you want!
 	// client.Publish(differentTopicId, e) // or, if you Publish to a second, valid topicID, the Ensign client will create another new Publisher!
 
-	// Goroutine to check the events channel to ensure that subscriber is getting all the events!
-	time.Sleep(1 * time.Second)
-	for msg := range sub.C {
-		var m MessageInABottle
-		if err := json.Unmarshal(msg.Data, &m); err != nil {
-			panic(fmt.Errorf("failed to unmarshal message: %s", err))
+	// Wait for our message to come back, acking (and skipping) anything else we read
+	msg, err := WaitForMessage(sub, stats, topic, id, *timeout)
+	if err != nil {
+		logger.Fatal("round trip failed", err, logger.KeyTopic, topic)
+	}
+
+	// The decoder is picked from the event mimetype after checking the event type version
+	var m MessageInABottle
+	if err := MessageInABottleType.Decode(msg, &m); err != nil {
+		logger.Fatal("failed to unmarshal message", err, logger.EventAttrs(topic, msg)...)
+	}
+	fmt.Printf("At %s,\n%s\nsent you the following message...\n'%s'\n", m.Timestamp, m.Sender, m.Message)
+
+	// The run passed so the ephemeral topic is no longer needed; if the run had failed the
+	// topic is left for debugging and will be swept up by a later run.
+	if *ephemeral {
+		if err = RemoveTopic(client, topicID, *cleanup); err != nil {
+			logger.Fatal("could not "+*cleanup+" ephemeral topic", err, logger.KeyTopic, topic)
+		}
+		log.Info("cleaned up ephemeral topic", logger.KeyTopic, topic, "cleanup", *cleanup)
+	}
+}
+
+// WaitForMessage reads events from the subscription until it finds the event tagged
+// with the message ID or the timeout expires. Every event read is acked so that stale
+// events from previous runs don't get redelivered.
+func WaitForMessage(sub *ensign.Subscription, stats *metrics.Metrics, topic, id string, timeout time.Duration) (*ensign.Event, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-deadline:
+			return nil, fmt.Errorf("message %s did not make the round trip within %s", id, timeout)
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil, errors.New("subscription closed before the message was received")
+			}
+
+			_, span := tracing.StartConsume(context.Background(), topic, event)
+			stats.Received(topic, event)
+			stats.Ack(topic, event)
+			span.End()
+
+			if event.Metadata.Get(MessageID) == id {
+				return event, nil
+			}
+			slog.Debug("skipping stale event", logger.KeyEventID, event.ID())
+		}
+	}
+}
+
+// publish the event to the topic with a publish span and count it in the metrics
+func publish(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, topic string, e *ensign.Event) (err error) {
+	_, span := tracing.StartPublish(ctx, topic, e)
+	err = stats.Publish(client, topic, e)
+	tracing.End(span, err)
+	return err
+}
+
+// EnsureTopic checks to see if the topic exists and creates it if it does not
+func EnsureTopic(client *ensign.Client, topic string) {
+	exists, err := client.TopicExists(context.Background(), topic)
+	if err != nil {
+		logger.Fatal("unable to check topic existence", err, logger.KeyTopic, topic)
+	}
+
+	if !exists {
+		if _, err = client.CreateTopic(context.Background(), topic); err != nil {
+			logger.Fatal("unable to create topic", err, logger.KeyTopic, topic)
 		}
-		fmt.Printf("At %s,\n%s\nsent you the following message...\n'%s'\n", m.Timestamp, m.Sender, m.Message)
-		return
 	}
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// EphemeralPrefix marks topics created for a single smoke test run so that they can be
+// found and swept up later if a run fails before cleaning up after itself.
+const EphemeralPrefix = "smoke-test-"
+
+// EphemeralTopic returns a uniquely named topic for this run, e.g. smoke-test-01h8...
+func EphemeralTopic() string {
+	return EphemeralPrefix + strings.ToLower(ulid.Make().String())
+}
+
+// CheckCleanup returns an error if mode is not a cleanup mode RemoveTopic understands.
+func CheckCleanup(mode string) error {
+	switch mode {
+	case "archive", "destroy":
+		return nil
+	default:
+		return fmt.Errorf("unknown cleanup mode %q: use archive or destroy", mode)
+	}
+}
+
+// RemoveTopic archives (makes read-only) or destroys the topic with the given ID using
+// the topic management API. The go-ensign client doesn't implement ArchiveTopic or
+// DestroyTopic yet so this calls the DeleteTopic RPC directly.
+func RemoveTopic(client *ensign.Client, topicID string, mode string) (err error) {
+	mod := &api.TopicMod{Id: topicID}
+	switch mode {
+	case "archive":
+		mod.Operation = api.TopicMod_ARCHIVE
+	case "destroy":
+		mod.Operation = api.TopicMod_DESTROY
+	default:
+		return CheckCleanup(mode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = client.EnsignClient().DeleteTopic(ctx, mod)
+	return err
+}
+
+// SweepTopics removes any ephemeral topics that are older than the given age, these
+// are left behind by runs that failed or were interrupted before cleaning up.
+func SweepTopics(client *ensign.Client, olderThan time.Duration, mode string) (swept int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var topics []*api.Topic
+	if topics, err = client.ListTopics(ctx); err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	for _, topic := range topics {
+		if !strings.HasPrefix(topic.Name, EphemeralPrefix) {
+			continue
+		}
+
+		// Archived topics have already been cleaned up unless they need to be destroyed
+		if topic.Readonly && mode == "archive" {
+			continue
+		}
+
+		if topic.Created == nil || topic.Created.AsTime().After(cutoff) {
+			continue
+		}
+
+		var topicID ulid.ULID
+		if err = topicID.UnmarshalBinary(topic.Id); err != nil {
+			return swept, err
+		}
+
+		if err = RemoveTopic(client, topicID.String(), mode); err != nil {
+			return swept, fmt.Errorf("could not %s stale topic %s: %w", mode, topic.Name, err)
+		}
+		slog.Info("swept stale topic", logger.KeyTopic, topic.Name, "cleanup", mode)
+		swept++
+	}
+	return swept, nil
+}
@@ -3,7 +3,7 @@ package parse
 // Parse performs parsing on Baleen documents transmitted via Ensign stream
 
 import (
-	"fmt"
+	"log/slog"
 
 	"github.com/anaskhan96/soup"
 	"github.com/cdipaolo/sentiment"
@@ -18,11 +18,11 @@ import (
 func ParseResponse(document *events.Document, model sentiment.Models) (entities map[string]string, avgSentiment float32, err error) {
 	var titleEnts map[string]string
 	if titleEnts, err = ParseString(document.Title); err != nil {
-		fmt.Println(err)
+		slog.Warn("could not parse document title", "error", err)
 	}
 	var articleEnts map[string]string
 	if articleEnts, avgSentiment, err = ParseHTML(document.Content, model); err != nil {
-		fmt.Println(err)
+		slog.Warn("could not parse document content", "error", err)
 	}
 	entities = make(map[string]string)
 	for tEnt, tTag := range titleEnts {
@@ -3,97 +3,153 @@ package main
 import (
 	"context"
 	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"time"
+	"os/signal"
 
 	"github.com/cdipaolo/sentiment"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
 
 	post "github.com/rotationalio/baleen/events"
 	"github.com/rotationalio/ensign-examples/go/nlp/parse"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
 	ensign "github.com/rotationalio/go-ensign"
 )
 
 // This is the nickname of the topic, which gets mapped to an ID that actually gets used by Ensign
 const Baleen = "baleen-docs"
 
+// The versions of the Baleen events that the subscriber can handle
+var (
+	FeedItemType = types.MustRegister("FeedItem", "1.0.0", post.FeedItem{}, "")
+	DocumentType = types.MustRegister("Document", "1.0.0", post.Document{}, "")
+)
+
 func main() {
+	// Pick the credentials with -credentials path/to/key.json or -profile dev
+	creds := config.RegisterFlags(flag.CommandLine)
+	// Pick the log output with -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	logs := logger.RegisterFlags(flag.CommandLine)
+	// Serve Prometheus metrics with -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	prom := metrics.RegisterFlags(flag.CommandLine)
+	// Export a trace of every event with -trace-exporter otlp (or file) or $ENSIGN_TRACE_EXPORTER
+	traces := tracing.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	log := logs.Setup()
+	m := prom.Setup()
+	defer traces.Setup("nlp")()
+
 	// Create Ensign Client
-	client, err := ensign.New()
+	client, err := creds.Client()
 	if err != nil {
-		panic(fmt.Errorf("could not create client: %s", err))
+		logger.Fatal("could not create client", err)
 	}
-	fmt.Printf("Ensign connection established at %s\n", time.Now().String())
+	log.Info("Ensign connection established")
 
 	// Check to see if topic exists and create it if not
 	exists, err := client.TopicExists(context.Background(), Baleen)
 	if err != nil {
-		panic(fmt.Errorf("unable to check topic existence: %s", err))
+		logger.Fatal("unable to check topic existence", err, logger.KeyTopic, Baleen)
 	}
 
 	if !exists {
 		if _, err = client.CreateTopic(context.Background(), Baleen); err != nil {
-			panic(fmt.Errorf("unable to create topic: %s", err))
+			logger.Fatal("unable to create topic", err, logger.KeyTopic, Baleen)
 		}
 	}
 
 	// Create a downstream consumer for the event stream
 	sub, err := client.Subscribe(Baleen)
 	if err != nil {
-		panic(fmt.Errorf("could not create subscriber: %s", err))
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Baleen)
 	}
 	defer sub.Close()
 
 	// Load the sentiment model
 	var model sentiment.Models
 	if model, err = sentiment.Restore(); err != nil {
-		panic("failed to load sentiment model" + err.Error())
+		logger.Fatal("failed to load sentiment model", err)
 	}
 
 	// Create files to store entity data
 	f, err := os.Create("entities.csv")
 	if err != nil {
-		panic("failed creating file" + err.Error())
+		logger.Fatal("failed creating file", err)
 	}
 	defer f.Close()
 
-	// Events are processed as they show up on the channel
-	for event := range sub.C {
-		if event.Type.Name == "FeedItem" {
-			fmt.Printf("FeedItem detected at %s\n", time.Now().String())
-		}
-		if event.Type.Name == "Document" {
-			fmt.Printf("document detected at %s\n", time.Now().String())
-
-			doc := &post.Document{}
-			if _, err = doc.UnmarshalMsg(event.Data); err != nil {
-				panic("failed to unmarshal event: " + err.Error())
-			}
-			fmt.Println("received document")
-			var entities map[string]string
-			var avgSentiment float32
-			if entities, avgSentiment, err = parse.ParseResponse(doc, model); err != nil {
-				fmt.Println("failed to extract entities from response:", err)
-			}
-			writer := csv.NewWriter(f)
-			defer writer.Flush()
-			for ent, tag := range entities {
-				// Construct the rows; each row has:
-				// Extracted entity, entity type, article title, article date, article link, average sentiment)
-				var row []string
-
-				row = append(row, ent, tag, doc.Title, doc.FetchedAt.String(), doc.Link, fmt.Sprintf("%f", avgSentiment))
-
-				// Write the rows
-				if err = writer.Write(row); err != nil {
-					fmt.Println("failed to write extracted entities to csv:", err)
-				}
-
-			}
-			fmt.Println("stored extracted entities to csv")
-
-		} else {
-			fmt.Printf("no document events for now (%s)...\n", time.Now().String())
+	extractor := &EntityExtractor{model: model, writer: csv.NewWriter(f)}
+
+	// Events are dispatched to a handler by their type; a handler that panics or
+	// returns an error is retried and then the event is moved to the baleen-docs-dlq
+	// topic instead of stopping the subscriber. Handlers log with the event's topic,
+	// ID, type and trace ID through the logger in their context.
+	stats := &mux.Stats{}
+	dlq := &deadletter.Consumer{Client: client, Topic: Baleen}
+	events := mux.New()
+	events.Use(mux.Logging(log, Baleen), tracing.Middleware(Baleen), mux.Metrics(stats), m.Middleware(Baleen), dlq.Middleware, mux.Recovery())
+	events.HandleType(FeedItemType, func(ctx context.Context, event *ensign.Event) error {
+		logger.FromContext(ctx).Info("FeedItem detected")
+		return nil
+	})
+	events.HandleType(DocumentType, mux.Decoded(DocumentType, extractor.Handle))
+	events.Fallback(func(ctx context.Context, event *ensign.Event) error {
+		logger.FromContext(ctx).Info("no document events for now...")
+		return nil
+	})
+
+	// Events are processed as they show up until ctrl-c is pressed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = events.Serve(ctx, sub); err != nil && !errors.Is(err, context.Canceled) {
+		logger.Fatal("subscriber stopped", err, logger.KeyTopic, Baleen)
+	}
+	fmt.Print(stats)
+}
+
+// EntityExtractor parses the entities and sentiment from each document and writes them
+// to the csv file
+type EntityExtractor struct {
+	model  sentiment.Models
+	writer *csv.Writer
+}
+
+// Handle a Document event, the document has already been decoded by the mux
+func (e *EntityExtractor) Handle(ctx context.Context, event *ensign.Event, doc post.Document) (err error) {
+	log := logger.FromContext(ctx).With("link", doc.Link)
+	log.Info("document detected")
+
+	var entities map[string]string
+	var avgSentiment float32
+	if entities, avgSentiment, err = parse.ParseResponse(&doc, e.model); err != nil {
+		log.Warn("failed to extract entities from response", "error", err)
+	}
+
+	for ent, tag := range entities {
+		// Construct the rows; each row has:
+		// Extracted entity, entity type, article title, article date, article link, average sentiment)
+		var row []string
+
+		row = append(row, ent, tag, doc.Title, doc.FetchedAt.String(), doc.Link, fmt.Sprintf("%f", avgSentiment))
+
+		// Write the rows
+		if err = e.writer.Write(row); err != nil {
+			log.Error("failed to write extracted entities to csv", "error", err)
 		}
 	}
+
+	e.writer.Flush()
+	if err = e.writer.Error(); err != nil {
+		return err
+	}
+	log.Info("stored extracted entities to csv", "entities", len(entities))
+	return nil
 }
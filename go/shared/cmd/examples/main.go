@@ -0,0 +1,43 @@
+/*
+Command examples is a helper for working on the Go examples. The new command generates a
+new example module from templates instead of copying go/boilerplate by hand:
+
+	go run ./go/shared/cmd/examples new -name weather -topic readings -source http -sink postgres
+
+The redrive command publishes the events in a dead letter topic back to their source
+topic once the consumer that failed to handle them has been fixed:
+
+	go run ./go/shared/cmd/examples redrive -topic trades -profile dev
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Commands are run by passing the name of the command as the first argument
+var commands = map[string]func(args []string){
+	"new":     New,
+	"redrive": Redrive,
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: examples <command> [flags]\n\ncommands: %s\n", strings.Join(names, ", "))
+	os.Exit(2)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+)
+
+// Redrive publishes the events in a dead letter topic back to the topic they came from,
+// e.g. after deploying a fix for the bug that made the consumer fail. Ensign only
+// delivers events published after the subscription starts, so run the redrive while the
+// consumer is still dead lettering events or before the failed events are resent.
+func Redrive(args []string) {
+	fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+	topic := fs.String("topic", "", "source topic to redrive, dead letters are read from <topic>"+deadletter.Suffix)
+	eventType := fs.String("type", "", "only redrive events with this event type name")
+	limit := fs.Int("limit", 0, "stop after redriving this many events (0 for no limit)")
+	idle := fs.Duration("idle", 30*time.Second, "stop when no dead letters arrive for this long (0 to run until ctrl-c)")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	fs.Parse(args)
+	log := logs.Setup()
+
+	if *topic == "" {
+		fmt.Fprintln(os.Stderr, "specify the source topic to redrive with -topic")
+		os.Exit(2)
+	}
+
+	client, err := creds.Client()
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	redrive := &deadletter.Redrive{
+		Client: client,
+		Topic:  *topic,
+		Type:   *eventType,
+		Limit:  *limit,
+		Idle:   *idle,
+	}
+
+	log.Info("redriving dead letters", "dlq", deadletter.Topic(*topic), logger.KeyTopic, *topic)
+	n, err := redrive.Run(ctx)
+	log.Info("sent dead letters back to their source topic", "redriven", n, logger.KeyTopic, *topic)
+	if err != nil {
+		logger.Fatal("could not redrive dead letters", err, "dlq", deadletter.Topic(*topic))
+	}
+}
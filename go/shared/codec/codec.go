@@ -0,0 +1,148 @@
+/*
+Package codec encodes and decodes event payloads and keeps the Ensign mimetype of each
+event in sync with the format of its data. Publishers pick a codec and Encode sets the
+matching mimetype on the event; consumers call Decode, which looks up the codec from the
+event mimetype instead of assuming a format.
+
+Ensign doesn't define mimetypes for gob or CBOR so they use user specified mimetypes,
+which every publisher and consumer of a topic must agree on.
+*/
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	ensign "github.com/rotationalio/go-ensign"
+	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
+)
+
+// User specified mimetypes for the formats that Ensign doesn't define
+const (
+	MimeGob  = mimetype.MIME_USER_SPECIFIED0
+	MimeCBOR = mimetype.MIME_USER_SPECIFIED1
+)
+
+var (
+	ErrUnknownMimetype = errors.New("no codec registered for mimetype")
+	ErrUnknownCodec    = errors.New("no codec registered with name")
+)
+
+// Codec encodes values into event data and decodes event data back into values
+type Codec interface {
+	Name() string
+	Mimetype() mimetype.MIME
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The codecs that are registered by default
+var (
+	JSON     Codec = jsonCodec{}
+	Msgpack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+	Gob      Codec = gobCodec{}
+	CBOR     Codec = cborCodec{}
+)
+
+// Default is the registry used by the package level functions
+var Default = NewRegistry(JSON, Msgpack, Protobuf, Gob, CBOR)
+
+// Registry maps mimetypes and names to codecs
+type Registry struct {
+	sync.RWMutex
+	mimetypes map[mimetype.MIME]Codec
+	names     map[string]Codec
+}
+
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{
+		mimetypes: make(map[mimetype.MIME]Codec, len(codecs)),
+		names:     make(map[string]Codec, len(codecs)),
+	}
+
+	for _, codec := range codecs {
+		r.Register(codec)
+	}
+	return r
+}
+
+// Register the codec, replacing any codec with the same mimetype or name
+func (r *Registry) Register(codec Codec) {
+	r.Lock()
+	defer r.Unlock()
+	r.mimetypes[codec.Mimetype()] = codec
+	r.names[codec.Name()] = codec
+}
+
+// Lookup the codec for the mimetype
+func (r *Registry) Lookup(mime mimetype.MIME) (Codec, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if codec, ok := r.mimetypes[mime]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownMimetype, mime.MimeType())
+}
+
+// Named returns the codec with the name, e.g. to select a codec with a flag
+func (r *Registry) Named(name string) (Codec, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if codec, ok := r.names[strings.ToLower(name)]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%w %q: use one of %s", ErrUnknownCodec, name, strings.Join(r.namesLocked(), ", "))
+}
+
+// Names returns the sorted names of the registered codecs
+func (r *Registry) Names() []string {
+	r.RLock()
+	defer r.RUnlock()
+	return r.namesLocked()
+}
+
+func (r *Registry) namesLocked() []string {
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode the event data into v using the codec for the event mimetype
+func (r *Registry) Decode(event *ensign.Event, v interface{}) error {
+	codec, err := r.Lookup(event.Mimetype)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(event.Data, v)
+}
+
+// Encode v as the event data using the codec and set the matching mimetype
+func Encode(codec Codec, event *ensign.Event, v interface{}) (err error) {
+	if event.Data, err = codec.Marshal(v); err != nil {
+		return fmt.Errorf("could not encode %T as %s: %w", v, codec.Name(), err)
+	}
+	event.Mimetype = codec.Mimetype()
+	return nil
+}
+
+// Decode the event data into v using the default registry
+func Decode(event *ensign.Event, v interface{}) error {
+	return Default.Decode(event, v)
+}
+
+// Register the codec with the default registry
+func Register(codec Codec) {
+	Default.Register(codec)
+}
+
+// Named returns the codec with the name from the default registry
+func Named(name string) (Codec, error) {
+	return Default.Named(name)
+}
@@ -0,0 +1,89 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
+	"github.com/tinylib/msgp/msgp"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Mimetype() mimetype.MIME                    { return mimetype.ApplicationJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// msgpackCodec uses the generated msgp methods when the type has them (e.g. the baleen
+// events) and falls back to reflection for everything else.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string            { return "msgpack" }
+func (msgpackCodec) Mimetype() mimetype.MIME { return mimetype.MIME_APPLICATION_MSGPACK }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(msgp.Marshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) (err error) {
+	if m, ok := v.(msgp.Unmarshaler); ok {
+		_, err = m.UnmarshalMsg(data)
+		return err
+	}
+	return msgpack.Unmarshal(data, v)
+}
+
+// protobufCodec can only encode and decode protocol buffer messages
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string            { return "protobuf" }
+func (protobufCodec) Mimetype() mimetype.MIME { return mimetype.MIME_APPLICATION_PROTOBUF }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protocol buffer message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a protocol buffer message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string            { return "gob" }
+func (gobCodec) Mimetype() mimetype.MIME { return MimeGob }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string                               { return "cbor" }
+func (cborCodec) Mimetype() mimetype.MIME                    { return MimeCBOR }
+func (cborCodec) Marshal(v interface{}) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v interface{}) error { return cbor.Unmarshal(data, v) }
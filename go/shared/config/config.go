@@ -0,0 +1,179 @@
+/*
+Package config loads the Ensign API key credentials for the examples so that they don't
+have to be exported in the shell profile or pasted into the code.
+
+Credentials are looked up in the following order, the first source found wins:
+
+ 1. the key file passed with -credentials (or $ENSIGN_CREDENTIALS)
+ 2. the named profile passed with -profile (or $ENSIGN_PROFILE)
+ 3. the $ENSIGN_CLIENT_ID and $ENSIGN_CLIENT_SECRET environment variables
+ 4. the "default" profile if it exists
+
+A profile is the JSON key file downloaded from https://rotational.app saved without
+modification as ~/.ensign/<profile>.json (e.g. dev.json, staging.json and prod.json);
+set $ENSIGN_CONFIG_DIR to keep the profiles somewhere else. Key files must not be
+readable by other users (chmod 600).
+*/
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Environment variables that select the credentials
+const (
+	EnvCredentials  = "ENSIGN_CREDENTIALS"
+	EnvProfile      = "ENSIGN_PROFILE"
+	EnvConfigDir    = "ENSIGN_CONFIG_DIR"
+	EnvClientID     = ensign.EnvClientID
+	EnvClientSecret = ensign.EnvClientSecret
+)
+
+// DefaultProfile is used if no other source of credentials is found
+const DefaultProfile = "default"
+
+var (
+	ErrNoCredentials = errors.New("no Ensign credentials found: use -credentials or -profile, or set $ENSIGN_CLIENT_ID and $ENSIGN_CLIENT_SECRET")
+	validProfile     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Credentials are the API key client ID and secret, the JSON tags match the key file
+// downloaded from rotational.app. Source describes where the credentials came from.
+type Credentials struct {
+	ClientID     string `json:"ClientID"`
+	ClientSecret string `json:"ClientSecret"`
+	Source       string `json:"-"`
+}
+
+// Options returns the ensign client options to connect with these credentials
+func (c *Credentials) Options() []ensign.Option {
+	return []ensign.Option{ensign.WithCredentials(c.ClientID, c.ClientSecret)}
+}
+
+// Flags are the command line flags that select the credentials
+type Flags struct {
+	Credentials string
+	Profile     string
+}
+
+// RegisterFlags adds the -credentials and -profile flags to the flag set
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Credentials, "credentials", "", "path to the JSON API key file downloaded from rotational.app (or $"+EnvCredentials+")")
+	fs.StringVar(&f.Profile, "profile", "", "named credentials profile, e.g. dev, staging or prod (or $"+EnvProfile+")")
+	return f
+}
+
+// Load the credentials selected by the flags (see the package docs for the order)
+func (f *Flags) Load() (*Credentials, error) {
+	return Load(f.Credentials, f.Profile)
+}
+
+// Client loads the credentials, reports which source supplied them and connects to
+// Ensign. Any additional options are applied after the credentials.
+func (f *Flags) Client(opts ...ensign.Option) (client *ensign.Client, err error) {
+	var creds *Credentials
+	if creds, err = f.Load(); err != nil {
+		return nil, err
+	}
+
+	slog.Info("using Ensign credentials", "source", creds.Source)
+	return ensign.New(append(creds.Options(), opts...)...)
+}
+
+// Load the credentials from the key file path or named profile, falling back to the
+// environment and then the default profile if neither is specified.
+func Load(path, profile string) (*Credentials, error) {
+	switch {
+	case path != "":
+		return LoadKeyFile(path, "key file "+path)
+	case profile != "":
+		return LoadProfile(profile)
+	}
+
+	if path = os.Getenv(EnvCredentials); path != "" {
+		return LoadKeyFile(path, fmt.Sprintf("key file %s ($%s)", path, EnvCredentials))
+	}
+
+	if profile = os.Getenv(EnvProfile); profile != "" {
+		return LoadProfile(profile)
+	}
+
+	if clientID, clientSecret := os.Getenv(EnvClientID), os.Getenv(EnvClientSecret); clientID != "" || clientSecret != "" {
+		if clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("both $%s and $%s must be set", EnvClientID, EnvClientSecret)
+		}
+		return &Credentials{ClientID: clientID, ClientSecret: clientSecret, Source: "environment ($" + EnvClientID + ")"}, nil
+	}
+
+	if path, err := ProfilePath(DefaultProfile); err == nil {
+		if _, err = os.Stat(path); err == nil {
+			return LoadProfile(DefaultProfile)
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// LoadProfile loads the key file for the named profile from the config directory
+func LoadProfile(profile string) (*Credentials, error) {
+	path, err := ProfilePath(profile)
+	if err != nil {
+		return nil, err
+	}
+	return LoadKeyFile(path, fmt.Sprintf("profile %s (%s)", profile, path))
+}
+
+// ProfilePath returns the path of the key file for the named profile
+func ProfilePath(profile string) (_ string, err error) {
+	if !validProfile.MatchString(profile) {
+		return "", fmt.Errorf("invalid profile name %q: use letters, numbers, dashes and underscores", profile)
+	}
+
+	dir := os.Getenv(EnvConfigDir)
+	if dir == "" {
+		var home string
+		if home, err = os.UserHomeDir(); err != nil {
+			return "", fmt.Errorf("could not find the config directory, set $%s: %w", EnvConfigDir, err)
+		}
+		dir = filepath.Join(home, ".ensign")
+	}
+	return filepath.Join(dir, profile+".json"), nil
+}
+
+// LoadKeyFile reads a JSON API key file, refusing to use it if anyone can read it
+func LoadKeyFile(path, source string) (creds *Credentials, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", source, err)
+	}
+
+	// Windows doesn't use unix permission bits so the check only applies elsewhere
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+		return nil, fmt.Errorf("refusing to use %s: it is readable by everyone (mode %s), run chmod 600 %s", source, info.Mode().Perm(), path)
+	}
+
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", source, err)
+	}
+
+	creds = &Credentials{Source: source}
+	if err = json.Unmarshal(data, creds); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", source, err)
+	}
+
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return nil, fmt.Errorf("%s is missing the ClientID or ClientSecret", source)
+	}
+	return creds, nil
+}
@@ -0,0 +1,257 @@
+/*
+Package deadletter keeps a bad event from taking down a consumer. The Consumer middleware
+retries a failed handler with bounded attempts and exponential backoff, and if the event
+still fails it is forwarded to the "<topic>-dlq" dead letter topic with its original
+metadata and the reason it failed, then nacked with the handler's nack code:
+
+	dlq := &deadletter.Consumer{Client: client, Topic: "trades"}
+	events := mux.New()
+	events.Use(dlq.Middleware, mux.Recovery())
+
+Events that can't be decoded fail the same way on every attempt so they are dead
+lettered without retrying. Once the cause of the failures is fixed, Redrive publishes
+the dead lettered events back to the topic they came from.
+*/
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/pipeline"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// Suffix is appended to the source topic to get the name of the dead letter topic
+const Suffix = "-dlq"
+
+// Metadata keys added to dead lettered events alongside the original metadata
+const (
+	KeySourceTopic = "dlq_source_topic"
+	KeyReason      = "dlq_reason"
+	KeyNackCode    = "dlq_nack_code"
+	KeyAttempts    = "dlq_attempts"
+	KeyFailedAt    = "dlq_failed_at"
+	KeyRedrives    = "dlq_redrives"
+)
+
+// Topic returns the name of the dead letter topic for the source topic
+func Topic(source string) string {
+	return source + Suffix
+}
+
+// Client is the part of the Ensign client used to dead letter and redrive events, it is
+// implemented by *ensign.Client.
+type Client interface {
+	tracing.Publisher
+	pipeline.Topics
+	Subscribe(topics ...string) (*ensign.Subscription, error)
+}
+
+// Policy bounds the number of attempts to handle an event and the backoff between them
+type Policy struct {
+	Attempts   int           // total attempts including the first
+	Backoff    time.Duration // delay before the first retry, doubled for each retry
+	MaxBackoff time.Duration // upper bound of the delay between retries
+}
+
+// DefaultPolicy is used by a Consumer without a policy
+var DefaultPolicy = Policy{Attempts: 3, Backoff: 200 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// Delay returns the backoff after the attempt (starting at 1) has failed
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Retryable returns false for errors that fail the same way on every attempt, i.e.
+// events nacked because their type or mimetype can't be decoded.
+func Retryable(err error) bool {
+	switch mux.NackCode(err) {
+	case api.Nack_UNKNOWN_TYPE, api.Nack_UNHANDLED_MIMETYPE:
+		return false
+	default:
+		return true
+	}
+}
+
+// Consumer retries and dead letters the events from the source topic
+type Consumer struct {
+	Client    Client
+	Topic     string           // the source topic, dead letters go to Topic + Suffix
+	Policy    Policy           // defaults to DefaultPolicy
+	Retryable func(error) bool // defaults to Retryable
+}
+
+// Middleware retries the handler according to the policy and forwards the event to the
+// dead letter topic if every attempt fails. The original error is returned so that the
+// event is nacked with its code; if the event could not be dead lettered it is nacked
+// with DELIVER_AGAIN_ANY instead so that it isn't lost.
+func (c *Consumer) Middleware(next mux.HandlerFunc) mux.HandlerFunc {
+	policy := c.Policy
+	if policy.Attempts == 0 {
+		policy = DefaultPolicy
+	}
+
+	retryable := c.Retryable
+	if retryable == nil {
+		retryable = Retryable
+	}
+
+	return func(ctx context.Context, event *ensign.Event) (err error) {
+		attempts := 0
+		for {
+			attempts++
+			if err = next(ctx, event); err == nil {
+				return nil
+			}
+
+			if attempts >= policy.Attempts || !retryable(err) {
+				break
+			}
+			logger.FromContext(ctx).Debug("retrying event", "attempt", attempts, "error", err)
+
+			// Stopping while waiting to retry leaves the event for the next consumer
+			select {
+			case <-ctx.Done():
+				return mux.Nack(api.Nack_DELIVER_AGAIN_ANY, err)
+			case <-time.After(policy.Delay(attempts)):
+			}
+		}
+
+		if dlqErr := c.DeadLetter(ctx, event, err, attempts); dlqErr != nil {
+			return mux.Nack(api.Nack_DELIVER_AGAIN_ANY, fmt.Errorf("%s (could not dead letter event: %w)", err, dlqErr))
+		}
+		logger.FromContext(ctx).Warn("moved event to the dead letter topic", "dlq", Topic(c.Topic), "attempts", attempts, "error", err)
+		return err
+	}
+}
+
+// DeadLetter publishes a copy of the event to the dead letter topic with its original
+// metadata and the reason that it failed, creating the topic if it doesn't exist. The
+// copy is published in the trace of the handler so a redrive continues the same trace.
+func (c *Consumer) DeadLetter(ctx context.Context, event *ensign.Event, reason error, attempts int) (err error) {
+	topic := Topic(c.Topic)
+	if err = pipeline.EnsureTopic(ctx, c.Client, topic); err != nil {
+		return err
+	}
+
+	meta := make(ensign.Metadata, len(event.Metadata)+5)
+	for key, val := range event.Metadata {
+		meta.Set(key, val)
+	}
+	meta.Set(KeySourceTopic, c.Topic)
+	meta.Set(KeyReason, reason.Error())
+	meta.Set(KeyNackCode, mux.NackCode(reason).String())
+	meta.Set(KeyAttempts, strconv.Itoa(attempts))
+	meta.Set(KeyFailedAt, time.Now().UTC().Format(time.RFC3339Nano))
+
+	dead := &ensign.Event{
+		Metadata: meta,
+		Data:     event.Data,
+		Mimetype: event.Mimetype,
+		Type:     event.Type,
+	}
+	return tracing.Publish(ctx, c.Client, topic, dead)
+}
+
+// Redrive options, the zero value redrives every dead letter until the context is
+// canceled.
+type Redrive struct {
+	Client Client
+	Topic  string        // the source topic, dead letters are read from Topic + Suffix
+	Type   string        // only redrive events with this type name, if set
+	Limit  int           // stop after redriving this many events, if set
+	Idle   time.Duration // stop when no dead letters arrive for this long, if set
+}
+
+// Run subscribes to the dead letter topic and publishes each dead letter back to the
+// topic that it came from without the dead letter metadata, then acks the dead letter.
+// Returns the number of events that were redriven.
+func (r *Redrive) Run(ctx context.Context) (n int, err error) {
+	var sub *ensign.Subscription
+	if sub, err = r.Client.Subscribe(Topic(r.Topic)); err != nil {
+		return 0, fmt.Errorf("could not subscribe to %s: %w", Topic(r.Topic), err)
+	}
+	defer sub.Close()
+
+	var idle <-chan time.Time
+	for r.Limit == 0 || n < r.Limit {
+		if r.Idle > 0 {
+			idle = time.After(r.Idle)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return n, nil
+			}
+			return n, ctx.Err()
+		case <-idle:
+			return n, nil
+		case event, ok := <-sub.C:
+			if !ok {
+				return n, nil
+			}
+
+			if r.Type != "" && (event.Type == nil || event.Type.Name != r.Type) {
+				event.Nack(api.Nack_DELIVER_AGAIN_NOT_ME)
+				continue
+			}
+
+			if err = r.redrive(event); err != nil {
+				event.Nack(api.Nack_DELIVER_AGAIN_ANY)
+				return n, err
+			}
+			event.Ack()
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *Redrive) redrive(dead *ensign.Event) error {
+	topic := dead.Metadata.Get(KeySourceTopic)
+	if topic == "" {
+		topic = r.Topic
+	}
+
+	// Count the redrives so that events that keep failing are easy to spot
+	redrives, _ := strconv.Atoi(dead.Metadata.Get(KeyRedrives))
+
+	meta := make(ensign.Metadata, len(dead.Metadata))
+	for key, val := range dead.Metadata {
+		switch key {
+		case KeySourceTopic, KeyReason, KeyNackCode, KeyAttempts, KeyFailedAt:
+		default:
+			meta.Set(key, val)
+		}
+	}
+	meta.Set(KeyRedrives, strconv.Itoa(redrives+1))
+
+	event := &ensign.Event{
+		Metadata: meta,
+		Data:     dead.Data,
+		Mimetype: dead.Mimetype,
+		Type:     dead.Type,
+	}
+
+	if err := r.Client.Publish(topic, event); err != nil {
+		return fmt.Errorf("could not redrive event to %s: %w", topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// fakeClient records the published events and the created topics instead of sending
+// them to Ensign.
+type fakeClient struct {
+	sync.Mutex
+	topics     map[string]bool
+	published  map[string][]*ensign.Event
+	publishErr error
+}
+
+func newFakeClient(topics ...string) *fakeClient {
+	c := &fakeClient{topics: make(map[string]bool), published: make(map[string][]*ensign.Event)}
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+	return c
+}
+
+func (c *fakeClient) Publish(topic string, events ...*ensign.Event) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	c.published[topic] = append(c.published[topic], events...)
+	return nil
+}
+
+func (c *fakeClient) Subscribe(topics ...string) (*ensign.Subscription, error) {
+	return nil, errors.New("the fake client cannot subscribe")
+}
+
+func (c *fakeClient) TopicExists(ctx context.Context, topic string) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.topics[topic], nil
+}
+
+func (c *fakeClient) CreateTopic(ctx context.Context, topic string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.topics[topic] = true
+	return topic, nil
+}
+
+// failing returns a handler that fails with the error until it has been called n times
+func failing(n int, err error) (mux.HandlerFunc, *int) {
+	calls := 0
+	return func(ctx context.Context, event *ensign.Event) error {
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func testEvent() *ensign.Event {
+	return &ensign.Event{
+		Metadata: ensign.Metadata{"trade_key": "abc123"},
+		Data:     []byte(`{"symbol":"AAPL"}`),
+		Type:     &api.Type{Name: "Trade", MajorVersion: 1},
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{Attempts: 10, Backoff: 200 * time.Millisecond, MaxBackoff: 5 * time.Second}
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{5, 3200 * time.Millisecond},
+		{6, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if actual := policy.Delay(tc.attempt); actual != tc.expected {
+			t.Errorf("attempt %d: expected delay %s got %s", tc.attempt, tc.expected, actual)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("database is down"), true},
+		{mux.Nack(api.Nack_UNPROCESSED, errors.New("failed")), true},
+		{mux.Nack(api.Nack_DELIVER_AGAIN_ANY, errors.New("busy")), true},
+		{mux.Nack(api.Nack_UNKNOWN_TYPE, errors.New("bad type")), false},
+		{mux.Nack(api.Nack_UNHANDLED_MIMETYPE, errors.New("bad mimetype")), false},
+	}
+
+	for _, tc := range testCases {
+		if actual := Retryable(tc.err); actual != tc.retryable {
+			t.Errorf("%s: expected retryable %t", tc.err, tc.retryable)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	policy := Policy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	failed := errors.New("database is down")
+
+	testCases := []struct {
+		name     string
+		failures int
+		err      error
+		calls    int
+		dead     bool
+	}{
+		{"succeeds", 0, failed, 1, false},
+		{"succeeds on retry", 2, failed, 3, false},
+		{"every attempt fails", 3, failed, 3, true},
+		{"unknown type", 3, mux.Nack(api.Nack_UNKNOWN_TYPE, errors.New("bad type")), 1, true},
+		{"unhandled mimetype", 3, mux.Nack(api.Nack_UNHANDLED_MIMETYPE, errors.New("bad mimetype")), 1, true},
+	}
+
+	for _, tc := range testCases {
+		client := newFakeClient("trades")
+		consumer := &Consumer{Client: client, Topic: "trades", Policy: policy}
+		handler, calls := failing(tc.failures, tc.err)
+
+		err := consumer.Middleware(handler)(context.Background(), testEvent())
+		if *calls != tc.calls {
+			t.Errorf("%s: expected %d calls to the handler got %d", tc.name, tc.calls, *calls)
+		}
+
+		dead := client.published[Topic("trades")]
+		if !tc.dead {
+			if err != nil || len(dead) != 0 {
+				t.Errorf("%s: expected the event to be handled, got error %v and %d dead letters", tc.name, err, len(dead))
+			}
+			continue
+		}
+
+		// The original error is returned so that the event is nacked with its code
+		if !errors.Is(err, tc.err) || mux.NackCode(err) != mux.NackCode(tc.err) {
+			t.Errorf("%s: expected the handler error to be returned, got %v", tc.name, err)
+		}
+
+		if len(dead) != 1 {
+			t.Fatalf("%s: expected 1 dead letter got %d", tc.name, len(dead))
+		}
+		if !client.topics[Topic("trades")] {
+			t.Errorf("%s: expected the dead letter topic to be created", tc.name)
+		}
+
+		meta := dead[0].Metadata
+		for key, expected := range map[string]string{
+			"trade_key":    "abc123",
+			KeySourceTopic: "trades",
+			KeyReason:      tc.err.Error(),
+			KeyNackCode:    mux.NackCode(tc.err).String(),
+			KeyAttempts:    strconv.Itoa(tc.calls),
+		} {
+			if actual := meta.Get(key); actual != expected {
+				t.Errorf("%s: expected dead letter metadata %s=%q got %q", tc.name, key, expected, actual)
+			}
+		}
+		if meta.Get(KeyFailedAt) == "" {
+			t.Errorf("%s: expected the dead letter to record when it failed", tc.name)
+		}
+		if string(dead[0].Data) != `{"symbol":"AAPL"}` || dead[0].Type.Name != "Trade" {
+			t.Errorf("%s: expected the dead letter to be a copy of the event", tc.name)
+		}
+	}
+}
+
+func TestMiddlewareDeadLetterFails(t *testing.T) {
+	client := newFakeClient()
+	client.publishErr = errors.New("ensign is unavailable")
+
+	consumer := &Consumer{Client: client, Topic: "trades", Policy: Policy{Attempts: 2, Backoff: time.Millisecond}}
+	handler, calls := failing(2, mux.Nack(api.Nack_UNPROCESSED, errors.New("failed")))
+
+	// The event is left for another consumer rather than nacked with the handler code
+	err := consumer.Middleware(handler)(context.Background(), testEvent())
+	if mux.NackCode(err) != api.Nack_DELIVER_AGAIN_ANY {
+		t.Errorf("expected DELIVER_AGAIN_ANY when the event can't be dead lettered, got %v", err)
+	}
+	if !errors.Is(err, client.publishErr) || !strings.Contains(err.Error(), "could not dead letter event") {
+		t.Errorf("expected the dead letter error to be returned, got %v", err)
+	}
+	if *calls != 2 {
+		t.Errorf("expected 2 calls to the handler got %d", *calls)
+	}
+}
+
+func TestMiddlewareCanceled(t *testing.T) {
+	client := newFakeClient("trades")
+	consumer := &Consumer{Client: client, Topic: "trades", Policy: Policy{Attempts: 3, Backoff: time.Hour}}
+	handler, calls := failing(3, errors.New("failed"))
+
+	// Stopping while waiting to retry neither dead letters the event nor drops it
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := consumer.Middleware(handler)(ctx, testEvent())
+	if mux.NackCode(err) != api.Nack_DELIVER_AGAIN_ANY {
+		t.Errorf("expected DELIVER_AGAIN_ANY when stopped during a retry, got %v", err)
+	}
+	if *calls != 1 || len(client.published) != 0 {
+		t.Errorf("expected a single attempt and no dead letters, got %d attempts and %d topics", *calls, len(client.published))
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	client := newFakeClient()
+	redrive := &Redrive{Client: client, Topic: "trades"}
+
+	dead := testEvent()
+	dead.Metadata.Set(KeySourceTopic, "trades-enriched")
+	dead.Metadata.Set(KeyReason, "database is down")
+	dead.Metadata.Set(KeyNackCode, api.Nack_UNPROCESSED.String())
+	dead.Metadata.Set(KeyAttempts, "3")
+	dead.Metadata.Set(KeyFailedAt, time.Now().Format(time.RFC3339Nano))
+
+	if err := redrive.redrive(dead); err != nil {
+		t.Fatalf("could not redrive the event: %s", err)
+	}
+
+	// The event goes back to its source topic without the dead letter metadata
+	redriven := client.published["trades-enriched"]
+	if len(redriven) != 1 {
+		t.Fatalf("expected 1 event to be redriven to the source topic, got %d", len(redriven))
+	}
+
+	meta := redriven[0].Metadata
+	for _, key := range []string{KeySourceTopic, KeyReason, KeyNackCode, KeyAttempts, KeyFailedAt} {
+		if _, ok := meta[key]; ok {
+			t.Errorf("expected %s to be stripped from the redriven event", key)
+		}
+	}
+	if meta.Get("trade_key") != "abc123" || meta.Get(KeyRedrives) != "1" {
+		t.Errorf("expected the original metadata and a redrive count, got %v", meta)
+	}
+	if string(redriven[0].Data) != string(dead.Data) || redriven[0].Type != dead.Type {
+		t.Error("expected the redriven event to be a copy of the dead letter")
+	}
+
+	// Events that are dead lettered again keep counting their redrives, and events
+	// without a source topic go back to the topic being redriven
+	again := redriven[0]
+	if err := redrive.redrive(again); err != nil {
+		t.Fatalf("could not redrive the event: %s", err)
+	}
+	if redriven = client.published["trades"]; len(redriven) != 1 || redriven[0].Metadata.Get(KeyRedrives) != "2" {
+		t.Errorf("expected the event to be redriven to trades a second time, got %v", client.published)
+	}
+
+	client.publishErr = errors.New("ensign is unavailable")
+	if err := redrive.redrive(dead); !errors.Is(err, client.publishErr) {
+		t.Errorf("expected the publish error to be returned, got %v", err)
+	}
+}
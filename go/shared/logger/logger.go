@@ -0,0 +1,169 @@
+/*
+Package logger sets up structured logging with log/slog for the examples so that every
+example writes the same log format. The format (console or json) and the level are set
+with the -log-format and -log-level flags or the $ENSIGN_LOG_FORMAT and
+$ENSIGN_LOG_LEVEL environment variables:
+
+	logs := logger.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	log := logs.Setup()
+
+Setup also makes the logger the slog default, which sends the output of the standard
+log package through the same handler. Log lines about an event should be written with
+the logger returned by WithEvent (or FromContext in a mux handler) so that they include
+the topic, event ID and event type.
+*/
+package logger
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Environment variables used as the defaults for the flags
+const (
+	EnvLevel  = "ENSIGN_LOG_LEVEL"
+	EnvFormat = "ENSIGN_LOG_FORMAT"
+)
+
+// Output formats
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// LevelTrace is below debug for very verbose logs, e.g. watermill's trace logs
+const LevelTrace = slog.LevelDebug - 4
+
+// Attribute keys added to the log lines about an event
+const (
+	KeyTopic     = "topic"
+	KeyEventID   = "event_id"
+	KeyEventType = "event_type"
+)
+
+// Flags holds the logging options registered on a flag set
+type Flags struct {
+	Level  string
+	Format string
+}
+
+// RegisterFlags adds the -log-level and -log-format flags to the flag set, defaulting
+// to the environment variables and then to info level console output.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Level, "log-level", env(EnvLevel, "info"), "minimum level to log: trace, debug, info, warn or error (or $"+EnvLevel+")")
+	fs.StringVar(&f.Format, "log-format", env(EnvFormat, FormatConsole), "log output format: console or json (or $"+EnvFormat+")")
+	return f
+}
+
+// Logger returns a logger that writes to stderr with the level and format
+func (f *Flags) Logger() (_ *slog.Logger, err error) {
+	var level slog.Level
+	if level, err = ParseLevel(f.Level); err != nil {
+		return nil, err
+	}
+	return New(os.Stderr, f.Format, level)
+}
+
+// Setup creates the logger and makes it the default for slog and the log package.
+// Invalid flag values exit with status 2 like flag parsing errors do.
+func (f *Flags) Setup() *slog.Logger {
+	logger, err := f.Logger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New returns a logger that writes to w in the format at or above the level
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	switch strings.ToLower(format) {
+	case FormatConsole, "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q: use %s or %s", format, FormatConsole, FormatJSON)
+	}
+}
+
+// ParseLevel parses a level name such as "debug" or "WARN", including "trace"
+func ParseLevel(s string) (level slog.Level, err error) {
+	if strings.EqualFold(s, "trace") {
+		return LevelTrace, nil
+	}
+
+	if err = level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q: use trace, debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// replaceLevel names the trace level instead of logging it as DEBUG-4
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// Fatal logs the error with the default logger and exits with status 1, for the errors
+// that an example can't recover from.
+func Fatal(msg string, err error, args ...any) {
+	slog.Error(msg, append([]any{"error", err}, args...)...)
+	os.Exit(1)
+}
+
+// WithEvent returns a logger that adds the topic, event ID and event type to each line
+func WithEvent(logger *slog.Logger, topic string, event *ensign.Event) *slog.Logger {
+	return logger.With(EventAttrs(topic, event)...)
+}
+
+// EventAttrs returns the attributes that identify the event in a log line
+func EventAttrs(topic string, event *ensign.Event) []any {
+	eventType := ""
+	if event.Type != nil {
+		eventType = fmt.Sprintf("%s v%s", event.Type.Name, types.VersionOf(event.Type))
+	}
+	return []any{
+		slog.String(KeyTopic, topic),
+		slog.String(KeyEventID, event.ID()),
+		slog.String(KeyEventType, eventType),
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that carries the logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger in the context or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func env(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
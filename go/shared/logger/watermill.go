@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// Watermill adapts a slog logger to watermill's LoggerAdapter so that the routers,
+// publishers and subscribers log to the same output as the rest of the example.
+type Watermill struct {
+	logger *slog.Logger
+}
+
+var _ watermill.LoggerAdapter = &Watermill{}
+
+// NewWatermill returns a watermill logger that writes to the logger, or to the default
+// logger if it is nil.
+func NewWatermill(logger *slog.Logger) *Watermill {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Watermill{logger: logger}
+}
+
+func (w *Watermill) Error(msg string, err error, fields watermill.LogFields) {
+	w.log(slog.LevelError, msg, fields, slog.Any("error", err))
+}
+
+func (w *Watermill) Info(msg string, fields watermill.LogFields) {
+	w.log(slog.LevelInfo, msg, fields)
+}
+
+func (w *Watermill) Debug(msg string, fields watermill.LogFields) {
+	w.log(slog.LevelDebug, msg, fields)
+}
+
+func (w *Watermill) Trace(msg string, fields watermill.LogFields) {
+	w.log(LevelTrace, msg, fields)
+}
+
+func (w *Watermill) With(fields watermill.LogFields) watermill.LoggerAdapter {
+	return &Watermill{logger: w.logger.With(attrs(fields)...)}
+}
+
+func (w *Watermill) log(level slog.Level, msg string, fields watermill.LogFields, extra ...any) {
+	ctx := context.Background()
+	if !w.logger.Enabled(ctx, level) {
+		return
+	}
+	w.logger.Log(ctx, level, msg, append(attrs(fields), extra...)...)
+}
+
+// attrs converts the watermill fields into slog attributes
+func attrs(fields watermill.LogFields) []any {
+	args := make([]any, 0, len(fields))
+	for key, val := range fields {
+		args = append(args, slog.Any(key, val))
+	}
+	return args
+}
@@ -0,0 +1,224 @@
+/*
+Package metrics exports Prometheus metrics for the events that the examples publish and
+consume, labeled by topic and event type, on an HTTP /metrics endpoint. The endpoint is
+served on the address set with the -metrics-addr flag or $ENSIGN_METRICS_ADDR and is
+disabled if neither is set:
+
+	prom := metrics.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	m := prom.Setup()
+
+	err := m.Publish(client, "trades", event)
+	events.Use(m.Middleware("trades"))
+
+Handlers wrapped in the middleware count the consumed events and the handler latency,
+and count the event as acked or nacked from the error that the handler returns, i.e.
+the same way that mux.Serve and pipeline consumers ack and nack. Subscribers that ack
+and nack events themselves use Received, Ack and Nack instead. Watermill routers can
+register their metrics on the same Registry with watermill's metrics builder.
+*/
+package metrics
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// Namespace is the prefix of every metric name, e.g. ensign_events_published_total
+const Namespace = "ensign"
+
+// EnvAddr is the environment variable used as the default for the -metrics-addr flag
+const EnvAddr = "ENSIGN_METRICS_ADDR"
+
+// Label names
+const (
+	LabelTopic = "topic"
+	LabelType  = "type"
+	LabelCode  = "code"
+)
+
+// Metrics holds the event metrics and the registry that they are exported from
+type Metrics struct {
+	Registry        *prometheus.Registry
+	published       *prometheus.CounterVec
+	publishFailed   *prometheus.CounterVec
+	consumed        *prometheus.CounterVec
+	acked           *prometheus.CounterVec
+	nacked          *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+}
+
+// New registers the event metrics and the Go runtime and process metrics on a new
+// registry.
+func New() *Metrics {
+	labels := []string{LabelTopic, LabelType}
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_published_total",
+			Help:      "The number of events published to Ensign",
+		}, labels),
+		publishFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_publish_failed_total",
+			Help:      "The number of events that could not be published to Ensign",
+		}, labels),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_consumed_total",
+			Help:      "The number of events received from Ensign subscriptions",
+		}, labels),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_acked_total",
+			Help:      "The number of consumed events that were acked",
+		}, labels),
+		nacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "events_nacked_total",
+			Help:      "The number of consumed events that were nacked by nack code",
+		}, append(labels, LabelCode)),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "The time taken to handle a consumed event, including retries",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.published,
+		m.publishFailed,
+		m.consumed,
+		m.acked,
+		m.nacked,
+		m.handlerDuration,
+	)
+	return m
+}
+
+// Publish the events to the topic and count the events that were published or failed
+func (m *Metrics) Publish(client tracing.Publisher, topic string, events ...*ensign.Event) (err error) {
+	err = client.Publish(topic, events...)
+	for _, event := range events {
+		if err != nil {
+			m.publishFailed.WithLabelValues(topic, typeName(event)).Inc()
+		} else {
+			m.published.WithLabelValues(topic, typeName(event)).Inc()
+		}
+	}
+	return err
+}
+
+// Middleware counts the events handled for the topic, times the handler and counts the
+// event as acked if the handler returns nil or as nacked with the error's nack code.
+func (m *Metrics) Middleware(topic string) mux.Middleware {
+	return func(next mux.HandlerFunc) mux.HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) (err error) {
+			eventType := typeName(event)
+			m.consumed.WithLabelValues(topic, eventType).Inc()
+
+			start := time.Now()
+			err = next(ctx, event)
+			m.handlerDuration.WithLabelValues(topic, eventType).Observe(time.Since(start).Seconds())
+
+			if err != nil {
+				m.nacked.WithLabelValues(topic, eventType, mux.NackCode(err).String()).Inc()
+			} else {
+				m.acked.WithLabelValues(topic, eventType).Inc()
+			}
+			return err
+		}
+	}
+}
+
+// Received counts an event read from a subscription to the topic
+func (m *Metrics) Received(topic string, event *ensign.Event) {
+	m.consumed.WithLabelValues(topic, typeName(event)).Inc()
+}
+
+// Ack the event and count it
+func (m *Metrics) Ack(topic string, event *ensign.Event) {
+	event.Ack()
+	m.acked.WithLabelValues(topic, typeName(event)).Inc()
+}
+
+// Nack the event with the code and count it
+func (m *Metrics) Nack(topic string, event *ensign.Event, code api.Nack_Code) {
+	event.Nack(code)
+	m.nacked.WithLabelValues(topic, typeName(event), code.String()).Inc()
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Serve the metrics at /metrics on the address until the context is canceled
+func (m *Metrics) Serve(ctx context.Context, addr string) (err error) {
+	router := http.NewServeMux()
+	router.Handle("/metrics", m.Handler())
+	srv := &http.Server{Addr: addr, Handler: router, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	if err = srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Flags holds the metrics options registered on a flag set
+type Flags struct {
+	Addr string
+}
+
+// RegisterFlags adds the -metrics-addr flag to the flag set, defaulting to the
+// environment variable.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Addr, "metrics-addr", os.Getenv(EnvAddr), "address to serve Prometheus metrics on at /metrics, e.g. :2112 (or $"+EnvAddr+", disabled if empty)")
+	return f
+}
+
+// Setup creates the metrics and serves them in the background if an address was set.
+// The metrics are always returned so that the example can record them either way; a
+// server that fails is logged rather than stopping the example.
+func (f *Flags) Setup() *Metrics {
+	m := New()
+	if f.Addr != "" {
+		go func() {
+			slog.Info("serving metrics", "addr", f.Addr, "path", "/metrics")
+			if err := m.Serve(context.Background(), f.Addr); err != nil {
+				slog.Error("could not serve metrics", "addr", f.Addr, "error", err)
+			}
+		}()
+	}
+	return m
+}
+
+func typeName(event *ensign.Event) string {
+	if event.Type == nil || event.Type.Name == "" {
+		return "none"
+	}
+	return event.Type.Name
+}
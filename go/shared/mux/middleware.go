@@ -0,0 +1,143 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Logging puts a logger with the topic, event ID and event type of each event into the
+// handler context (see logger.FromContext) and logs the outcome of the handler: errors
+// at the error level and handled events at the debug level. Uses the default logger if
+// the logger is nil.
+func Logging(log *slog.Logger, topic string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) (err error) {
+			base := log
+			if base == nil {
+				base = slog.Default()
+			}
+
+			events := logger.WithEvent(base, topic, event)
+			ctx = logger.NewContext(ctx, events)
+
+			start := time.Now()
+			if err = next(ctx, event); err != nil {
+				events.Error("could not handle event", "error", err, "duration", time.Since(start))
+			} else {
+				events.Debug("handled event", "duration", time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// Recovery turns a panic in the handler into an error so that the event is nacked and
+// the subscriber keeps running.
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("handler panicked on %s: %v\n%s", typeName(event), r, debug.Stack())
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+// Observer records the outcome of every handled event, e.g. to export metrics
+type Observer interface {
+	Observe(eventType string, duration time.Duration, err error)
+}
+
+// Metrics times every handled event and reports it to the observer
+func Metrics(observer Observer) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) (err error) {
+			start := time.Now()
+			err = next(ctx, event)
+			observer.Observe(typeName(event), time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Stats is an in-memory Observer that counts the handled and failed events and sums
+// the handler durations for each event type.
+type Stats struct {
+	sync.Mutex
+	types map[string]*TypeStats
+}
+
+// TypeStats are the stats for a single event type
+type TypeStats struct {
+	Handled  uint64
+	Failed   uint64
+	Duration time.Duration
+}
+
+func (s *Stats) Observe(eventType string, duration time.Duration, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.types == nil {
+		s.types = make(map[string]*TypeStats)
+	}
+
+	stats, ok := s.types[eventType]
+	if !ok {
+		stats = &TypeStats{}
+		s.types[eventType] = stats
+	}
+
+	stats.Handled++
+	stats.Duration += duration
+	if err != nil {
+		stats.Failed++
+	}
+}
+
+// Snapshot returns a copy of the stats for each event type
+func (s *Stats) Snapshot() map[string]TypeStats {
+	s.Lock()
+	defer s.Unlock()
+	snapshot := make(map[string]TypeStats, len(s.types))
+	for name, stats := range s.types {
+		snapshot[name] = *stats
+	}
+	return snapshot
+}
+
+// String summarizes the stats with one line per event type
+func (s *Stats) String() string {
+	snapshot := s.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		stats := snapshot[name]
+		fmt.Fprintf(&b, "%-30s handled=%-6d failed=%-6d avg=%s\n", name, stats.Handled, stats.Failed, stats.Duration/time.Duration(stats.Handled))
+	}
+	return b.String()
+}
+
+func typeName(event *ensign.Event) string {
+	if event.Type == nil {
+		return "<none>"
+	}
+	return fmt.Sprintf("%s v%s", event.Type.Name, types.VersionOf(event.Type))
+}
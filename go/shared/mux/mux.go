@@ -0,0 +1,219 @@
+/*
+Package mux dispatches the events on a subscription to handlers registered by event type
+name and version range, so a subscriber that reads several event types from one topic
+doesn't have to branch on event.Type by hand:
+
+	m := mux.New()
+	m.Use(mux.Logging(nil, "baleen-docs"), mux.Recovery())
+	m.Handle("FeedItem", "1.x", handleFeedItem)
+	m.HandleType(DocumentType, mux.Decoded(DocumentType, handleDocument))
+	m.Fallback(func(ctx context.Context, event *ensign.Event) error { return nil })
+	err := m.Serve(ctx, sub)
+
+Handlers follow the same convention as the pipeline: returning nil acks the event and
+returning an error nacks it (use Nack to choose the code). Events that don't
+match a handler go to the fallback handler or are nacked with UNKNOWN_TYPE if there is
+no fallback.
+*/
+package mux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+var ErrNoHandler = errors.New("no handler registered for event type")
+
+// HandlerFunc handles an event; returning nil acks the event and an error nacks it
+type HandlerFunc func(ctx context.Context, event *ensign.Event) error
+
+// Middleware wraps a handler, e.g. to log or time every event that it handles
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type route struct {
+	versions types.Range
+	handler  HandlerFunc
+}
+
+// Mux routes events to the first handler registered for the event type name whose
+// version range contains the version of the event.
+type Mux struct {
+	sync.RWMutex
+	routes     map[string][]*route
+	fallback   HandlerFunc
+	middleware []Middleware
+}
+
+func New() *Mux {
+	return &Mux{routes: make(map[string][]*route)}
+}
+
+// Use adds middleware that wraps every handler including the fallback. Middleware is
+// applied when the event is dispatched, so it can be added before or after handlers.
+func (m *Mux) Use(middleware ...Middleware) {
+	m.Lock()
+	defer m.Unlock()
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// Handle registers the handler for events with the type name and a version in the
+// range (see types.ParseRange, e.g. "1.x" or ">=1.2 <3"), wrapped in the middleware.
+func (m *Mux) Handle(name, versions string, handler HandlerFunc, middleware ...Middleware) error {
+	r, err := types.ParseRange(versions)
+	if err != nil {
+		return fmt.Errorf("could not register handler for %s: %w", name, err)
+	}
+	m.HandleRange(name, r, handler, middleware...)
+	return nil
+}
+
+// HandleRange registers the handler for events with the type name and a version in the
+// already parsed range, wrapped in the middleware.
+func (m *Mux) HandleRange(name string, versions types.Range, handler HandlerFunc, middleware ...Middleware) {
+	m.Lock()
+	defer m.Unlock()
+	m.routes[name] = append(m.routes[name], &route{versions: versions, handler: Chain(handler, middleware)})
+}
+
+// HandleType registers the handler for events that are compatible with the registered
+// event type, i.e. that have the same name and major version.
+func (m *Mux) HandleType(t *types.EventType, handler HandlerFunc, middleware ...Middleware) {
+	m.HandleRange(t.Name, types.MajorRange(t.Version.Major), handler, middleware...)
+}
+
+// Fallback sets the handler for events that don't match any registered handler
+func (m *Mux) Fallback(handler HandlerFunc, middleware ...Middleware) {
+	m.Lock()
+	defer m.Unlock()
+	m.fallback = Chain(handler, middleware)
+}
+
+// Dispatch passes the event to the matching handler and returns its error
+func (m *Mux) Dispatch(ctx context.Context, event *ensign.Event) error {
+	m.RLock()
+	handler := m.match(event.Type)
+	middleware := m.middleware
+	m.RUnlock()
+
+	if handler == nil {
+		handler = noHandler
+	}
+	return Chain(handler, middleware)(ctx, event)
+}
+
+func (m *Mux) match(t *api.Type) HandlerFunc {
+	if t != nil {
+		version := types.VersionOf(t)
+		for _, r := range m.routes[t.Name] {
+			if r.versions.Contains(version) {
+				return r.handler
+			}
+		}
+	}
+	return m.fallback
+}
+
+// Serve dispatches the events on the subscription until the context is canceled or the
+// subscription is closed, acking each event if its handler returns nil and nacking it
+// otherwise.
+func (m *Mux) Serve(ctx context.Context, sub *ensign.Subscription) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			if err := m.Dispatch(ctx, event); err != nil {
+				event.Nack(NackCode(err))
+				continue
+			}
+			event.Ack()
+		}
+	}
+}
+
+// NackError is returned by a handler to nack the event with a specific code
+type NackError struct {
+	Code api.Nack_Code
+	Err  error
+}
+
+// Nack wraps the error so that the event is nacked with the code
+func Nack(code api.Nack_Code, err error) error {
+	return &NackError{Code: code, Err: err}
+}
+
+func (e *NackError) Error() string {
+	return fmt.Sprintf("nack %s: %s", e.Code, e.Err)
+}
+
+func (e *NackError) Unwrap() error {
+	return e.Err
+}
+
+// NackCode returns the code from a NackError or UNPROCESSED for other errors
+func NackCode(err error) api.Nack_Code {
+	var nack *NackError
+	if errors.As(err, &nack) {
+		return nack.Code
+	}
+	return api.Nack_UNPROCESSED
+}
+
+// DecodeFailed wraps an error from decoding an event so that the event is nacked with
+// UNHANDLED_MIMETYPE if there is no codec for its mimetype and UNKNOWN_TYPE otherwise.
+func DecodeFailed(err error) error {
+	if errors.Is(err, codec.ErrUnknownMimetype) {
+		return Nack(api.Nack_UNHANDLED_MIMETYPE, err)
+	}
+	return Nack(api.Nack_UNKNOWN_TYPE, err)
+}
+
+// Decoded adapts a handler of decoded values to a HandlerFunc. The event is decoded
+// with the event type, so events with an incompatible version or an unknown mimetype
+// are nacked without calling the handler. T can be the Go type of the event type or a
+// pointer to it; a pointer is decoded into a new value rather than a pointer to the
+// pointer, which the msgp and protobuf codecs can't decode into.
+func Decoded[T any](t *types.EventType, handler func(ctx context.Context, event *ensign.Event, v T) error) HandlerFunc {
+	return func(ctx context.Context, event *ensign.Event) error {
+		var v T
+		if err := t.Decode(event, Target(&v)); err != nil {
+			return DecodeFailed(err)
+		}
+		return handler(ctx, event, v)
+	}
+}
+
+// Target returns what to pass to a codec to decode into v: v itself, or if v points to
+// a pointer a newly allocated value that v is set to, so that the codec decodes into
+// the value rather than a pointer to the pointer.
+func Target[T any](v *T) interface{} {
+	if goType := reflect.TypeOf(*v); goType != nil && goType.Kind() == reflect.Ptr {
+		*v = reflect.New(goType.Elem()).Interface().(T)
+		return *v
+	}
+	return v
+}
+
+func noHandler(ctx context.Context, event *ensign.Event) error {
+	return Nack(api.Nack_UNKNOWN_TYPE, fmt.Errorf("%w %s", ErrNoHandler, typeName(event)))
+}
+
+// Chain wraps the handler in the middleware so that the first middleware is outermost
+func Chain(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
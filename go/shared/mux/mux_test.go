@@ -0,0 +1,249 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// note implements the msgp interfaces by hand like the code generated by msgp, with
+// a short key that the reflection based msgpack decoder doesn't know about.
+type note struct {
+	Title string `msg:"t"`
+}
+
+func (n *note) MarshalMsg(b []byte) ([]byte, error) {
+	b = msgp.AppendMapHeader(b, 1)
+	b = msgp.AppendString(b, "t")
+	return msgp.AppendString(b, n.Title), nil
+}
+
+func (n *note) UnmarshalMsg(b []byte) (_ []byte, err error) {
+	var size uint32
+	if size, b, err = msgp.ReadMapHeaderBytes(b); err != nil {
+		return b, err
+	}
+
+	for i := uint32(0); i < size; i++ {
+		var key string
+		if key, b, err = msgp.ReadStringBytes(b); err != nil {
+			return b, err
+		}
+
+		switch key {
+		case "t":
+			n.Title, b, err = msgp.ReadStringBytes(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func typed(name string, major, minor uint32) *ensign.Event {
+	return &ensign.Event{Type: &api.Type{Name: name, MajorVersion: major, MinorVersion: minor}}
+}
+
+// handled returns a handler that records its name when it is called
+func handled(calls *[]string, name string) HandlerFunc {
+	return func(ctx context.Context, event *ensign.Event) error {
+		*calls = append(*calls, name)
+		return nil
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	var calls []string
+	noteType := types.NewRegistry().MustRegister("Note", "2.1.0", note{}, "")
+
+	m := New()
+	if err := m.Handle("Trade", "1.x", handled(&calls, "trade v1")); err != nil {
+		t.Fatalf("could not register handler: %s", err)
+	}
+	if err := m.Handle("Trade", ">=1.2 <3", handled(&calls, "trade v1.2-v2")); err != nil {
+		t.Fatalf("could not register handler: %s", err)
+	}
+	if err := m.Handle("Trade", "one", handled(&calls, "invalid")); err == nil {
+		t.Error("expected an invalid version range to fail")
+	}
+	m.HandleType(noteType, handled(&calls, "note v2"))
+
+	testCases := []struct {
+		event   *ensign.Event
+		handler string
+	}{
+		{typed("Trade", 1, 0), "trade v1"},
+		{typed("Trade", 1, 5), "trade v1"}, // the first matching route wins
+		{typed("Trade", 2, 3), "trade v1.2-v2"},
+		{typed("Note", 2, 0), "note v2"},
+		{typed("Note", 2, 9), "note v2"},
+	}
+
+	for _, tc := range testCases {
+		calls = nil
+		if err := m.Dispatch(context.Background(), tc.event); err != nil {
+			t.Errorf("%s: unexpected error %s", typeName(tc.event), err)
+		}
+		if len(calls) != 1 || calls[0] != tc.handler {
+			t.Errorf("%s: expected handler %q got %v", typeName(tc.event), tc.handler, calls)
+		}
+	}
+
+	// Events that don't match any route are nacked with UNKNOWN_TYPE
+	for _, event := range []*ensign.Event{typed("Trade", 3, 0), typed("Note", 1, 0), typed("Quote", 1, 0), {}} {
+		calls = nil
+		err := m.Dispatch(context.Background(), event)
+		if !errors.Is(err, ErrNoHandler) || NackCode(err) != api.Nack_UNKNOWN_TYPE {
+			t.Errorf("%s: expected an UNKNOWN_TYPE nack, got %v", typeName(event), err)
+		}
+		if len(calls) != 0 {
+			t.Errorf("%s: expected no handler to be called, got %v", typeName(event), calls)
+		}
+	}
+
+	// Unless there is a fallback
+	m.Fallback(handled(&calls, "fallback"))
+	for _, event := range []*ensign.Event{typed("Trade", 3, 0), {}} {
+		calls = nil
+		if err := m.Dispatch(context.Background(), event); err != nil || len(calls) != 1 || calls[0] != "fallback" {
+			t.Errorf("%s: expected the fallback handler, got %v (%v)", typeName(event), calls, err)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx context.Context, event *ensign.Event) error {
+				calls = append(calls, name+" before")
+				err := next(ctx, event)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	handler := Chain(handled(&calls, "handler"), []Middleware{middleware("first"), middleware("second")})
+	if err := handler(context.Background(), typed("Trade", 1, 0)); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	expected := "first before, second before, handler, second after, first after"
+	if actual := strings.Join(calls, ", "); actual != expected {
+		t.Errorf("expected the first middleware to be outermost\nexpected: %s\ngot:      %s", expected, actual)
+	}
+
+	// Mux middleware wraps the route middleware and also wraps the fallback
+	m := New()
+	m.Use(middleware("mux"))
+	m.Handle("Trade", "*", handled(&calls, "handler"), middleware("route"))
+	m.Fallback(handled(&calls, "fallback"))
+
+	calls = nil
+	m.Dispatch(context.Background(), typed("Trade", 1, 0))
+	expected = "mux before, route before, handler, route after, mux after"
+	if actual := strings.Join(calls, ", "); actual != expected {
+		t.Errorf("expected the mux middleware to wrap the route middleware\nexpected: %s\ngot:      %s", expected, actual)
+	}
+
+	calls = nil
+	m.Dispatch(context.Background(), typed("Quote", 1, 0))
+	expected = "mux before, fallback, mux after"
+	if actual := strings.Join(calls, ", "); actual != expected {
+		t.Errorf("expected the mux middleware to wrap the fallback\nexpected: %s\ngot:      %s", expected, actual)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	handler := Chain(func(ctx context.Context, event *ensign.Event) error {
+		panic("boom")
+	}, []Middleware{Recovery()})
+
+	err := handler(context.Background(), typed("Trade", 1, 0))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected the panic to be returned as an error, got %v", err)
+	}
+	if NackCode(err) != api.Nack_UNPROCESSED {
+		t.Errorf("expected a panic to be nacked as UNPROCESSED, got %s", NackCode(err))
+	}
+}
+
+func TestNackCode(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code api.Nack_Code
+	}{
+		{errors.New("failed"), api.Nack_UNPROCESSED},
+		{Nack(api.Nack_DELIVER_AGAIN_ANY, errors.New("try again")), api.Nack_DELIVER_AGAIN_ANY},
+		{DecodeFailed(codec.ErrUnknownMimetype), api.Nack_UNHANDLED_MIMETYPE},
+		{DecodeFailed(types.ErrIncompatible), api.Nack_UNKNOWN_TYPE},
+	}
+
+	for _, tc := range testCases {
+		if code := NackCode(tc.err); code != tc.code {
+			t.Errorf("%s: expected code %s got %s", tc.err, tc.code, code)
+		}
+	}
+}
+
+func TestDecoded(t *testing.T) {
+	noteType := types.NewRegistry().MustRegister("Note", "1.0.0", note{}, "")
+
+	event := &ensign.Event{}
+	if err := noteType.Encode(codec.Msgpack, event, &note{Title: "Ensign"}); err != nil {
+		t.Fatalf("could not encode the note: %s", err)
+	}
+
+	// Values and pointers are both decoded with the msgp methods of the type
+	var value note
+	handler := Decoded(noteType, func(ctx context.Context, event *ensign.Event, n note) error {
+		value = n
+		return nil
+	})
+	if err := handler(context.Background(), event); err != nil || value.Title != "Ensign" {
+		t.Errorf("expected the value handler to get the note, got %+v (%v)", value, err)
+	}
+
+	var pointer *note
+	handler = Decoded(noteType, func(ctx context.Context, event *ensign.Event, n *note) error {
+		pointer = n
+		return nil
+	})
+	if err := handler(context.Background(), event); err != nil || pointer == nil || pointer.Title != "Ensign" {
+		t.Errorf("expected the pointer handler to get the note, got %+v (%v)", pointer, err)
+	}
+
+	// Events that can't be decoded are nacked without calling the handler
+	called := false
+	handler = Decoded(noteType, func(ctx context.Context, event *ensign.Event, n note) error {
+		called = true
+		return nil
+	})
+
+	event.Type.MajorVersion = 2
+	if err := handler(context.Background(), event); NackCode(err) != api.Nack_UNKNOWN_TYPE {
+		t.Errorf("expected an incompatible event to be nacked with UNKNOWN_TYPE, got %v", err)
+	}
+
+	event.Type.MajorVersion = 1
+	event.Mimetype = mimetype.TextCSV
+	if err := handler(context.Background(), event); NackCode(err) != api.Nack_UNHANDLED_MIMETYPE {
+		t.Errorf("expected an unknown mimetype to be nacked with UNHANDLED_MIMETYPE, got %v", err)
+	}
+
+	if called {
+		t.Error("expected the handler not to be called for events that can't be decoded")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Package pipeline removes the boilerplate from the examples: a Pipeline reads values of
+a Go type from a Source, encodes them with a codec and publishes them to an Ensign
+topic, while a typed Consumer decodes the events on the topic (using the codec for the
+mimetype of each event) and passes the values to a Handler that decides whether each
+event is acked or nacked. Events are stamped with and checked against the event type
+registered for the Go type in the types package. Everything runs until the context is
+canceled so an example only needs to supply the domain code:
+
+	var TickType = types.MustRegister("Tick", "1.0.0", Tick{}, "")
+
+	p := &pipeline.Pipeline[Tick]{
+		Client:  client,
+		Topic:   "ticks",
+		Source:  pipeline.Ticker(time.Second, fetchTick),
+		Handler: func(ctx context.Context, tick Tick) error { fmt.Println(tick); return nil },
+	}
+	err := p.Run(ctx)
+*/
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Pipeline publishes the values from the Source to the Topic and consumes them from
+// the Topic with the Handler. Either the Source or the Handler can be nil to run only
+// the publishing or consuming half of the pipeline.
+type Pipeline[T any] struct {
+	Client     *ensign.Client
+	Topic      string
+	Source     Source[T]
+	Handler    Handler[T]
+	Codec      codec.Codec      // used to publish, defaults to the codec of the event type
+	EventType  *types.EventType // defaults to the event type registered for T
+	Middleware []mux.Middleware // wraps the handler
+	Metrics    *metrics.Metrics // counts the published and consumed events, if set
+}
+
+// Run creates the topic if it doesn't exist and then publishes and consumes until the
+// context is canceled, the source is exhausted and the consumer is stopped, or either
+// half fails. Returns nil if the pipeline was stopped by canceling the context.
+func (p *Pipeline[T]) Run(ctx context.Context) (err error) {
+	if err = EnsureTopic(ctx, p.Client, p.Topic); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+	)
+
+	// The first half to fail stops the other half
+	fail := func(e error) {
+		if e != nil && !errors.Is(e, context.Canceled) {
+			once.Do(func() {
+				err = e
+				cancel()
+			})
+		}
+	}
+
+	// Subscribe before publishing so that none of the published events are missed
+	if p.Handler != nil {
+		consumer := &Consumer[T]{Client: p.Client, Topic: p.Topic, EventType: p.EventType, Handler: p.Handler, Middleware: p.Middleware, Metrics: p.Metrics}
+		var sub *ensign.Subscription
+		if sub, err = p.Client.Subscribe(p.Topic); err != nil {
+			return fmt.Errorf("could not subscribe to %s: %w", p.Topic, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sub.Close()
+			fail(consumer.Consume(ctx, sub))
+		}()
+	}
+
+	if p.Source != nil {
+		publisher := &Publisher[T]{Client: p.Client, Topic: p.Topic, Codec: p.Codec, EventType: p.EventType, Metrics: p.Metrics}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fail(publisher.PublishFrom(ctx, p.Source))
+		}()
+	}
+
+	wg.Wait()
+	return err
+}
+
+// Publisher encodes values with the codec and publishes them to the topic
+type Publisher[T any] struct {
+	Client    tracing.Publisher
+	Topic     string
+	Codec     codec.Codec      // defaults to the codec of the event type
+	EventType *types.EventType // defaults to the event type registered for T
+	Metrics   *metrics.Metrics // counts the published and failed events, if set
+}
+
+// Publish a single value with optional metadata. The event is published in a span that
+// continues the trace in the context and is injected into the event metadata.
+func (p *Publisher[T]) Publish(ctx context.Context, v T, meta ensign.Metadata) (err error) {
+	var eventType *types.EventType
+	if eventType, err = resolve[T](p.EventType); err != nil {
+		return err
+	}
+
+	e := &ensign.Event{Metadata: meta}
+	if err = eventType.Encode(p.Codec, e, v); err != nil {
+		return err
+	}
+
+	_, span := tracing.StartPublish(ctx, p.Topic, e)
+	if p.Metrics != nil {
+		err = p.Metrics.Publish(p.Client, p.Topic, e)
+	} else {
+		err = p.Client.Publish(p.Topic, e)
+	}
+
+	tracing.End(span, err)
+	return err
+}
+
+// PublishFrom publishes every value from the source until the source returns io.EOF
+// (returns nil), the context is canceled or publishing fails.
+func (p *Publisher[T]) PublishFrom(ctx context.Context, source Source[T]) error {
+	for {
+		v, err := source.Next(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err = p.Publish(ctx, v, nil); err != nil {
+			return fmt.Errorf("could not publish to %s: %w", p.Topic, err)
+		}
+	}
+}
+
+// Handler processes a decoded value; returning nil acks the event and returning an
+// error nacks it (use mux.Nack to choose the nack code, the default is UNPROCESSED).
+type Handler[T any] func(ctx context.Context, v T) error
+
+// Consumer decodes the events on the topic and passes the values to the handler. The
+// codec is chosen from the mimetype of each event so publishers can change formats, and
+// events with an incompatible event type are nacked without calling the handler.
+type Consumer[T any] struct {
+	Client     *ensign.Client
+	Topic      string
+	Codecs     *codec.Registry  // defaults to codec.Default
+	EventType  *types.EventType // defaults to the event type registered for T
+	Handler    Handler[T]
+	Middleware []mux.Middleware // wraps the handler, e.g. to retry and dead letter failures
+	Metrics    *metrics.Metrics // counts the consumed, acked and nacked events, if set
+}
+
+// Run subscribes to the topic and consumes events until the context is canceled
+func (c *Consumer[T]) Run(ctx context.Context) (err error) {
+	var sub *ensign.Subscription
+	if sub, err = c.Client.Subscribe(c.Topic); err != nil {
+		return fmt.Errorf("could not subscribe to %s: %w", c.Topic, err)
+	}
+	defer sub.Close()
+	return c.Consume(ctx, sub)
+}
+
+// Consume events from an existing subscription until the context is canceled or the
+// subscription is closed.
+func (c *Consumer[T]) Consume(ctx context.Context, sub *ensign.Subscription) (err error) {
+	var eventType *types.EventType
+	if eventType, err = resolve[T](c.EventType); err != nil {
+		return err
+	}
+
+	handler := c.handler(eventType)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			if err := handler(ctx, event); err != nil {
+				event.Nack(mux.NackCode(err))
+				continue
+			}
+			event.Ack()
+		}
+	}
+}
+
+// handler decodes the event and passes the value to the handler, wrapped in the
+// middleware. The handler context carries a logger with the topic, event ID, type and
+// trace ID, and the handler span that continues the trace from the event metadata.
+func (c *Consumer[T]) handler(eventType *types.EventType) mux.HandlerFunc {
+	codecs := c.Codecs
+	if codecs == nil {
+		codecs = codec.Default
+	}
+
+	middleware := []mux.Middleware{mux.Logging(nil, c.Topic), tracing.Middleware(c.Topic)}
+	if c.Metrics != nil {
+		middleware = append(middleware, c.Metrics.Middleware(c.Topic))
+	}
+
+	return mux.Chain(func(ctx context.Context, event *ensign.Event) error {
+		var v T
+		if err := eventType.DecodeWith(codecs, event, mux.Target(&v)); err != nil {
+			return mux.DecodeFailed(err)
+		}
+		return c.Handler(ctx, v)
+	}, append(middleware, c.Middleware...))
+}
+
+// resolve returns the event type if it is set or the event type registered for T
+func resolve[T any](eventType *types.EventType) (*types.EventType, error) {
+	if eventType != nil {
+		return eventType, nil
+	}
+
+	var v T
+	return types.TypeOf(&v)
+}
+
+// Topics checks for and creates topics, it is implemented by *ensign.Client
+type Topics interface {
+	TopicExists(ctx context.Context, topic string) (bool, error)
+	CreateTopic(ctx context.Context, topic string) (string, error)
+}
+
+// EnsureTopic checks to see if the topic exists and creates it if it does not
+func EnsureTopic(ctx context.Context, client Topics, topic string) (err error) {
+	var exists bool
+	if exists, err = client.TopicExists(ctx, topic); err != nil {
+		return fmt.Errorf("unable to check topic existence: %w", err)
+	}
+
+	if !exists {
+		if _, err = client.CreateTopic(ctx, topic); err != nil {
+			return fmt.Errorf("unable to create topic: %w", err)
+		}
+	}
+	return nil
+}
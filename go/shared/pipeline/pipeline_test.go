@@ -0,0 +1,239 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+type reading struct {
+	Station string  `json:"station"`
+	Temp    float64 `json:"temp"`
+}
+
+var readingType = types.NewRegistry().MustRegister("Reading", "1.0.0", reading{}, "")
+
+// fakeClient records the published events instead of sending them to Ensign
+type fakeClient struct {
+	sync.Mutex
+	published  map[string][]*ensign.Event
+	publishErr error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{published: make(map[string][]*ensign.Event)}
+}
+
+func (c *fakeClient) Publish(topic string, events ...*ensign.Event) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	c.published[topic] = append(c.published[topic], events...)
+	return nil
+}
+
+// subscription returns a subscription that delivers the events and is then closed
+func subscription(events []*ensign.Event) *ensign.Subscription {
+	c := make(chan *ensign.Event, len(events))
+	for _, event := range events {
+		c <- event
+	}
+	close(c)
+	return &ensign.Subscription{C: c}
+}
+
+// readings returns a source of the readings that is exhausted after the last one
+func readings(values ...reading) Source[reading] {
+	c := make(chan reading, len(values))
+	for _, v := range values {
+		c <- v
+	}
+	close(c)
+	return Channel(c)
+}
+
+// publishReadings publishes the readings with the fake client and returns the events
+func publishReadings(t *testing.T, values ...reading) []*ensign.Event {
+	client := newFakeClient()
+	publisher := &Publisher[reading]{Client: client, Topic: "readings", EventType: readingType}
+	if err := publisher.PublishFrom(context.Background(), readings(values...)); err != nil {
+		t.Fatalf("expected the publisher to stop without an error at EOF, got %s", err)
+	}
+
+	if len(client.published["readings"]) != len(values) {
+		t.Fatalf("expected %d events to be published, got %d", len(values), len(client.published["readings"]))
+	}
+	return client.published["readings"]
+}
+
+func TestRoundTrip(t *testing.T) {
+	expected := []reading{{"KDCA", 21.5}, {"KBWI", 19}, {"KIAD", -3.25}}
+	events := publishReadings(t, expected...)
+	for _, event := range events {
+		if err := readingType.Check(event.Type); err != nil {
+			t.Errorf("expected the events to be stamped with the event type: %s", err)
+		}
+	}
+
+	var values []reading
+	consumer := &Consumer[reading]{Topic: "readings", EventType: readingType, Handler: func(ctx context.Context, v reading) error {
+		values = append(values, v)
+		return nil
+	}}
+
+	if err := consumer.Consume(context.Background(), subscription(events)); err != nil {
+		t.Fatalf("expected the consumer to stop without an error when the subscription closes, got %s", err)
+	}
+
+	if len(values) != len(expected) {
+		t.Fatalf("expected %d readings to be consumed, got %d", len(expected), len(values))
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("expected reading %d to be %+v, got %+v", i, expected[i], values[i])
+		}
+	}
+}
+
+func TestRoundTripPointer(t *testing.T) {
+	// Protobuf messages can only be decoded into a pointer to the message, not a pointer
+	// to a pointer, so use an API type as the value of the event
+	protoType := types.NewRegistry().MustRegister("ProtoType", "1.0.0", &api.Type{}, "")
+
+	client := newFakeClient()
+	publisher := &Publisher[*api.Type]{Client: client, Topic: "types", Codec: codec.Protobuf, EventType: protoType}
+	if err := publisher.Publish(context.Background(), &api.Type{Name: "Reading", MajorVersion: 1}, nil); err != nil {
+		t.Fatalf("could not publish the type: %s", err)
+	}
+
+	var value *api.Type
+	consumer := &Consumer[*api.Type]{Topic: "types", EventType: protoType, Handler: func(ctx context.Context, v *api.Type) error {
+		value = v
+		return nil
+	}}
+
+	if err := consumer.Consume(context.Background(), subscription(client.published["types"])); err != nil {
+		t.Fatalf("could not consume the events: %s", err)
+	}
+
+	if value == nil || value.Name != "Reading" || value.MajorVersion != 1 {
+		t.Errorf("expected the pointer handler to get the decoded message, got %v", value)
+	}
+}
+
+func TestConsumeIncompatible(t *testing.T) {
+	events := publishReadings(t, reading{"KDCA", 21.5})
+	events[0].Type = &api.Type{Name: "Reading", MajorVersion: 2}
+
+	// Record the error the events are nacked with since the fake events can't be nacked
+	var nacked []error
+	record := func(next mux.HandlerFunc) mux.HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) error {
+			err := next(ctx, event)
+			nacked = append(nacked, err)
+			return err
+		}
+	}
+
+	called := false
+	consumer := &Consumer[reading]{Topic: "readings", EventType: readingType, Middleware: []mux.Middleware{record}, Handler: func(ctx context.Context, v reading) error {
+		called = true
+		return nil
+	}}
+
+	if err := consumer.Consume(context.Background(), subscription(events)); err != nil {
+		t.Fatalf("could not consume the events: %s", err)
+	}
+
+	if called {
+		t.Error("expected the handler not to be called for an incompatible event")
+	}
+	if len(nacked) != 1 || mux.NackCode(nacked[0]) != api.Nack_UNKNOWN_TYPE {
+		t.Errorf("expected the incompatible event to be nacked with UNKNOWN_TYPE, got %v", nacked)
+	}
+}
+
+func TestConsumeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The subscription is never closed so only the context can stop the consumer
+	consumer := &Consumer[reading]{Topic: "readings", EventType: readingType, Handler: func(ctx context.Context, v reading) error { return nil }}
+	sub := &ensign.Subscription{C: make(chan *ensign.Event)}
+	if err := consumer.Consume(ctx, sub); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the consumer to stop with the context error, got %v", err)
+	}
+}
+
+func TestPublishFrom(t *testing.T) {
+	// The source error is returned unless it is io.EOF
+	client := newFakeClient()
+	publisher := &Publisher[reading]{Client: client, Topic: "readings", EventType: readingType}
+
+	failed := errors.New("station offline")
+	source := SourceFunc[reading](func(ctx context.Context) (reading, error) { return reading{}, failed })
+	if err := publisher.PublishFrom(context.Background(), source); !errors.Is(err, failed) {
+		t.Errorf("expected the source error to be returned, got %v", err)
+	}
+
+	// Publishing stops at the first publish error
+	client.publishErr = errors.New("connection reset")
+	if err := publisher.PublishFrom(context.Background(), readings(reading{"KDCA", 21.5})); !errors.Is(err, client.publishErr) {
+		t.Errorf("expected the publish error to be returned, got %v", err)
+	}
+
+	// Canceling the context stops a source that is waiting for a value
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client.publishErr = nil
+	if err := publisher.PublishFrom(ctx, Channel(make(chan reading))); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the publisher to stop with the context error, got %v", err)
+	}
+
+	if len(client.published["readings"]) != 0 {
+		t.Errorf("expected no events to be published, got %d", len(client.published["readings"]))
+	}
+}
+
+func TestTicker(t *testing.T) {
+	// Fetch errors are skipped until the next tick and io.EOF stops the source
+	calls := 0
+	source := Ticker(time.Millisecond, func(ctx context.Context) (reading, error) {
+		calls++
+		switch calls {
+		case 1:
+			return reading{}, errors.New("api timed out")
+		case 2:
+			return reading{"KDCA", 21.5}, nil
+		default:
+			return reading{}, io.EOF
+		}
+	})
+
+	v, err := source.Next(context.Background())
+	if err != nil || v.Station != "KDCA" || calls != 2 {
+		t.Fatalf("expected the ticker to retry the failed fetch, got %+v after %d calls (%v)", v, calls, err)
+	}
+
+	if _, err = source.Next(context.Background()); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the ticker to stop at EOF, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = source.Next(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the ticker to stop with the context error, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Source produces the values to publish. Next blocks until the next value is ready and
+// returns io.EOF when there are no more values; it should return the context error if
+// the context is canceled while waiting.
+type Source[T any] interface {
+	Next(ctx context.Context) (T, error)
+}
+
+// SourceFunc adapts a function to the Source interface
+type SourceFunc[T any] func(ctx context.Context) (T, error)
+
+func (f SourceFunc[T]) Next(ctx context.Context) (T, error) {
+	return f(ctx)
+}
+
+// Ticker returns a source that calls fetch every interval, e.g. to poll an API, so the
+// caller doesn't have to sleep between requests. A failed fetch is logged and retried
+// on the next tick so that a transient error (e.g. the API timing out) doesn't stop
+// the pipeline; fetch can return io.EOF to stop the source.
+func Ticker[T any](interval time.Duration, fetch func(ctx context.Context) (T, error)) Source[T] {
+	ticker := time.NewTicker(interval)
+	return SourceFunc[T](func(ctx context.Context) (v T, err error) {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return v, ctx.Err()
+			case <-ticker.C:
+				if v, err = fetch(ctx); err == nil || errors.Is(err, io.EOF) || ctx.Err() != nil {
+					return v, err
+				}
+				slog.Warn("could not fetch the next value, retrying on the next tick", "error", err, "interval", interval)
+			}
+		}
+	})
+}
+
+// Channel returns a source that reads values from the channel until it is closed
+func Channel[T any](c <-chan T) Source[T] {
+	return SourceFunc[T](func(ctx context.Context) (v T, err error) {
+		select {
+		case <-ctx.Done():
+			return v, ctx.Err()
+		case v, ok := <-c:
+			if !ok {
+				return v, io.EOF
+			}
+			return v, nil
+		}
+	})
+}
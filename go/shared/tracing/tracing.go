@@ -0,0 +1,258 @@
+/*
+Package tracing follows events across Ensign hops with OpenTelemetry. Publishers start a
+span and inject its W3C traceparent into the event metadata, and consumers extract it so
+that the consume and handler spans (and anything the handler publishes) join the same
+trace. Spans are exported over OTLP or written to a local file, chosen with the
+-trace-exporter and -trace-file flags or $ENSIGN_TRACE_EXPORTER and $ENSIGN_TRACE_FILE:
+
+	traces := tracing.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	defer traces.Setup("trades")()
+
+	err := tracing.Publish(ctx, client, "trades", event)
+	events.Use(tracing.Middleware("trades"))
+
+The OTLP exporter sends spans over HTTP to the collector configured with the standard
+$OTEL_EXPORTER_OTLP_ENDPOINT variable (localhost:4318 by default). When tracing is
+disabled the traceparent of consumed events is still passed on to the events that are
+published while handling them, so a trace isn't broken by a stage that doesn't export.
+*/
+package tracing
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	ensign "github.com/rotationalio/go-ensign"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Environment variables used as the defaults for the flags
+const (
+	EnvExporter = "ENSIGN_TRACE_EXPORTER"
+	EnvFile     = "ENSIGN_TRACE_FILE"
+)
+
+// Span exporters
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// Name is the instrumentation name of the tracer
+const Name = "github.com/rotationalio/ensign-examples/go/shared/tracing"
+
+// KeyTraceID is the log attribute key for the trace ID
+const KeyTraceID = "trace_id"
+
+// Propagator reads and writes the W3C traceparent, tracestate and baggage metadata
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func init() {
+	otel.SetTextMapPropagator(Propagator)
+}
+
+// Tracer returns the tracer used for the publish, consume and handler spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Flags holds the tracing options registered on a flag set
+type Flags struct {
+	Exporter string
+	File     string
+}
+
+// RegisterFlags adds the -trace-exporter and -trace-file flags to the flag set,
+// defaulting to the environment variables and then to no tracing.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Exporter, "trace-exporter", env(EnvExporter, ExporterNone), "where to export spans: none, otlp or file (or $"+EnvExporter+")")
+	fs.StringVar(&f.File, "trace-file", env(EnvFile, "traces.jsonl"), "file that spans are appended to by the file exporter (or $"+EnvFile+")")
+	return f
+}
+
+// Setup installs a tracer provider that exports the spans of the named service and
+// returns a function that flushes the remaining spans, to defer in main. Exporters
+// that can't be created exit with status 1 like other setup errors.
+func (f *Flags) Setup(service string) (shutdown func()) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch strings.ToLower(f.Exporter) {
+	case ExporterNone, "":
+		return func() {}
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background())
+	case ExporterFile:
+		var file io.WriteCloser
+		if file, err = os.OpenFile(f.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		err = fmt.Errorf("unknown trace exporter %q: use %s, %s or %s", f.Exporter, ExporterNone, ExporterOTLP, ExporterFile)
+	}
+
+	if err != nil {
+		logger.Fatal("could not create trace exporter", err, "exporter", f.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("exporting traces", "exporter", f.Exporter, "service", service)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Error("could not flush traces", "error", err)
+		}
+	}
+}
+
+// Inject writes the trace context into the metadata, e.g. the metadata of an Ensign
+// event or a watermill message.
+func Inject(ctx context.Context, metadata map[string]string) {
+	Propagator.Inject(ctx, propagation.MapCarrier(metadata))
+}
+
+// Extract returns a context with the remote trace context read from the metadata
+func Extract(ctx context.Context, metadata map[string]string) context.Context {
+	return Propagator.Extract(ctx, propagation.MapCarrier(metadata))
+}
+
+// Fields returns the metadata keys that carry the trace context
+func Fields() []string {
+	return Propagator.Fields()
+}
+
+// StartPublish starts a producer span for publishing the event to the topic and injects
+// it into the event metadata so that consumers continue the trace.
+func StartPublish(ctx context.Context, topic string, event *ensign.Event) (context.Context, trace.Span) {
+	ctx, span := Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes(topic, event)...),
+	)
+
+	if event.Metadata == nil {
+		event.Metadata = make(ensign.Metadata)
+	}
+	Inject(ctx, event.Metadata)
+	return ctx, span
+}
+
+// StartConsume starts a consumer span for an event received from the topic as a child
+// of the trace context in the event metadata.
+func StartConsume(ctx context.Context, topic string, event *ensign.Event) (context.Context, trace.Span) {
+	ctx = Extract(ctx, event.Metadata)
+	return Tracer().Start(ctx, topic+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes(topic, event)...),
+	)
+}
+
+// End records the error on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Publisher publishes events to a topic, it is implemented by *ensign.Client
+type Publisher interface {
+	Publish(topic string, events ...*ensign.Event) error
+}
+
+// Publish the events to the topic with a publish span for each event
+func Publish(ctx context.Context, client Publisher, topic string, events ...*ensign.Event) (err error) {
+	spans := make([]trace.Span, 0, len(events))
+	for _, event := range events {
+		_, span := StartPublish(ctx, topic, event)
+		spans = append(spans, span)
+	}
+
+	err = client.Publish(topic, events...)
+	for _, span := range spans {
+		End(span, err)
+	}
+	return err
+}
+
+// Middleware continues the trace of each event from the topic with a consume span and a
+// handler span; the handler context carries the handler span so that events published
+// by the handler with StartPublish or Publish join the same trace.
+func Middleware(topic string) mux.Middleware {
+	return func(next mux.HandlerFunc) mux.HandlerFunc {
+		return func(ctx context.Context, event *ensign.Event) (err error) {
+			var consume, handle trace.Span
+			ctx, consume = StartConsume(ctx, topic, event)
+			defer func() { End(consume, err) }()
+
+			ctx, handle = Tracer().Start(ctx, "handle "+typeName(event))
+			ctx = logger.NewContext(ctx, logger.FromContext(ctx).With(KeyTraceID, TraceID(ctx)))
+			err = next(ctx, event)
+			End(handle, err)
+			return err
+		}
+	}
+}
+
+// TraceID returns the ID of the trace in the context or an empty string
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+func attributes(topic string, event *ensign.Event) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("ensign"),
+		semconv.MessagingDestinationName(topic),
+		attribute.String(logger.KeyEventType, typeName(event)),
+	}
+
+	if id := event.ID(); id != "" {
+		attrs = append(attrs, semconv.MessagingMessageID(id))
+	}
+	return attrs
+}
+
+func typeName(event *ensign.Event) string {
+	if event.Type == nil || event.Type.Name == "" {
+		return "event"
+	}
+	return event.Type.Name
+}
+
+func env(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WatermillMiddleware continues the trace in the metadata of each message handled by a
+// watermill router with a handler span, and injects the handler span into the messages
+// that the handler produces so that their publish spans join the trace.
+func WatermillMiddleware(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) (produced []*message.Message, err error) {
+		ctx := Extract(msg.Context(), msg.Metadata)
+		ctx, span := Tracer().Start(ctx, "handle "+message.HandlerNameFromCtx(msg.Context()),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKey.String("watermill"),
+				semconv.MessagingDestinationName(message.SubscribeTopicFromCtx(msg.Context())),
+				semconv.MessagingMessageID(msg.UUID),
+			),
+		)
+		msg.SetContext(ctx)
+
+		produced, err = h(msg)
+		for _, out := range produced {
+			out.SetContext(ctx)
+			Inject(ctx, out.Metadata)
+		}
+
+		End(span, err)
+		return produced, err
+	}
+}
+
+// DecoratePublisher wraps a watermill publisher so that every message is published
+// with a producer span that is a child of the message context, and with the span
+// injected into the message metadata. It is a message.PublisherDecorator so it can be
+// passed to a router's AddPublisherDecorators.
+func DecoratePublisher(pub message.Publisher) (message.Publisher, error) {
+	return &publisher{pub}, nil
+}
+
+type publisher struct {
+	message.Publisher
+}
+
+func (p *publisher) Publish(topic string, messages ...*message.Message) (err error) {
+	spans := make([]trace.Span, 0, len(messages))
+	for _, msg := range messages {
+		ctx, span := Tracer().Start(msg.Context(), topic+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKey.String("watermill"),
+				semconv.MessagingDestinationName(topic),
+				semconv.MessagingMessageID(msg.UUID),
+			),
+		)
+		Inject(ctx, msg.Metadata)
+		spans = append(spans, span)
+	}
+
+	err = p.Publisher.Publish(topic, messages...)
+	for _, span := range spans {
+		End(span, err)
+	}
+	return err
+}
@@ -0,0 +1,327 @@
+/*
+Package types is a registry of the event types that the examples publish. Each example
+declares its types once with a name, a semantic version, the Go type of the payload and
+optionally a JSON schema (generated from the Go type if it isn't declared):
+
+	var TradesType = types.MustRegister("Trades", "1.2.0", Response{}, "")
+
+Publishers encode values through the registry, which stamps each event with the type
+registered for the Go type of the value, so no example has to build an api.Type by hand.
+Consumers decode through the registry, which checks the event type against the
+registered version before decoding. For a consumer that registered version 1.2.0:
+
+	event type       result
+	Trades v1.2.x    decoded
+	Trades v1.0.0    decoded, the fields added in 1.1 and 1.2 are left empty
+	Trades v1.3.0    decoded, the fields added in 1.3 are ignored
+	Trades v2.0.0    ErrIncompatible, unless an adapter is registered for major version 2
+	Trades v0.9.0    ErrIncompatible, unless an adapter is registered for major version 0
+	Generic v1.0.0   ErrTypeMismatch
+
+Consumers should nack events that fail the check with the UNKNOWN_TYPE code.
+*/
+package types
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+var (
+	ErrUnknownType       = errors.New("no event type registered")
+	ErrAmbiguousType     = errors.New("more than one event type registered for go type")
+	ErrAlreadyRegistered = errors.New("event type already registered")
+	ErrTypeMismatch      = errors.New("event type does not match")
+	ErrIncompatible      = errors.New("incompatible event type version")
+)
+
+// Adapter decodes an event published with a different major version of the type into
+// v, which is a pointer to the currently registered Go type, e.g. by decoding into the
+// old struct and converting it.
+type Adapter func(event *ensign.Event, v interface{}) error
+
+// EventType is a registered event type
+type EventType struct {
+	Name    string
+	Version Version
+	GoType  reflect.Type
+	Schema  string      // JSON schema of the payload
+	Codec   codec.Codec // used when no codec is passed to Encode, defaults to JSON
+
+	mu       sync.RWMutex
+	adapters map[uint32]Adapter
+}
+
+// Type returns the Ensign event type to stamp on published events
+func (t *EventType) Type() *api.Type {
+	return &api.Type{
+		Name:         t.Name,
+		MajorVersion: t.Version.Major,
+		MinorVersion: t.Version.Minor,
+		PatchVersion: t.Version.Patch,
+	}
+}
+
+func (t *EventType) String() string {
+	return fmt.Sprintf("%s v%s", t.Name, t.Version)
+}
+
+// Adapt registers an adapter for events published with another major version of the
+// type. Returns the event type so adapters can be chained onto the registration.
+func (t *EventType) Adapt(major uint32, adapter Adapter) *EventType {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.adapters == nil {
+		t.adapters = make(map[uint32]Adapter)
+	}
+	t.adapters[major] = adapter
+	return t
+}
+
+func (t *EventType) adapter(major uint32) (Adapter, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	adapter, ok := t.adapters[major]
+	return adapter, ok
+}
+
+// Check returns nil if the incoming event type can be decoded as this type, either
+// because it has a compatible version or because an adapter is registered for its
+// major version.
+func (t *EventType) Check(incoming *api.Type) error {
+	if incoming == nil {
+		return fmt.Errorf("%w: expected %s, event has no type", ErrTypeMismatch, t)
+	}
+
+	if incoming.Name != t.Name {
+		return fmt.Errorf("%w: expected %s, got %s v%s", ErrTypeMismatch, t, incoming.Name, VersionOf(incoming))
+	}
+
+	version := VersionOf(incoming)
+	if !Compatible(t.Version, version) {
+		if _, ok := t.adapter(version.Major); !ok {
+			return fmt.Errorf("%w: cannot decode %s v%s as v%s", ErrIncompatible, t.Name, version, t.Version)
+		}
+	}
+	return nil
+}
+
+// Encode v as the event data with the codec (or the default codec of the type if nil)
+// and stamp the event with the type.
+func (t *EventType) Encode(c codec.Codec, event *ensign.Event, v interface{}) error {
+	if goType := indirect(reflect.TypeOf(v)); goType != t.GoType {
+		return fmt.Errorf("cannot encode %s as %s: registered for %s", goType, t, t.GoType)
+	}
+
+	if c == nil {
+		if c = t.Codec; c == nil {
+			c = codec.JSON
+		}
+	}
+
+	if err := codec.Encode(c, event, v); err != nil {
+		return err
+	}
+	event.Type = t.Type()
+	return nil
+}
+
+// NewEvent returns an event with the metadata and v encoded with the default codec
+func (t *EventType) NewEvent(v interface{}, meta ensign.Metadata) (*ensign.Event, error) {
+	event := &ensign.Event{Metadata: meta}
+	if err := t.Encode(nil, event, v); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Decode the event into v with the codec for its mimetype after checking that the
+// event type is compatible, using an adapter for other major versions.
+func (t *EventType) Decode(event *ensign.Event, v interface{}) error {
+	return t.DecodeWith(codec.Default, event, v)
+}
+
+// DecodeWith is Decode with a specific codec registry
+func (t *EventType) DecodeWith(codecs *codec.Registry, event *ensign.Event, v interface{}) error {
+	if err := t.Check(event.Type); err != nil {
+		return err
+	}
+
+	if version := VersionOf(event.Type); !Compatible(t.Version, version) {
+		adapter, _ := t.adapter(version.Major)
+		return adapter(event, v)
+	}
+	return codecs.Decode(event, v)
+}
+
+// Default is the registry used by the package level functions
+var Default = NewRegistry()
+
+// Registry maps names and Go types to event types
+type Registry struct {
+	sync.RWMutex
+	names   map[string]*EventType
+	goTypes map[reflect.Type]*EventType
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names:   make(map[string]*EventType),
+		goTypes: make(map[reflect.Type]*EventType),
+	}
+}
+
+// Register an event type with the version (e.g. "1.2.0"), an example value of its Go
+// type and its JSON schema; if the schema is empty it is generated from the Go type.
+func (r *Registry) Register(name, version string, example interface{}, schema string) (_ *EventType, err error) {
+	if name == "" {
+		return nil, errors.New("event type name is required")
+	}
+
+	if example == nil {
+		return nil, fmt.Errorf("an example value is required to register %s", name)
+	}
+
+	t := &EventType{
+		Name:   name,
+		GoType: indirect(reflect.TypeOf(example)),
+		Schema: schema,
+	}
+
+	if t.Version, err = ParseVersion(version); err != nil {
+		return nil, fmt.Errorf("could not register %s: %w", name, err)
+	}
+
+	if t.Schema == "" {
+		t.Schema = Schema(t)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.names[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyRegistered, name)
+	}
+	r.names[name] = t
+
+	// Several event types can share a Go type, e.g. a request and a reply, but then the
+	// type can't be inferred from the value and the event type must be used directly.
+	if _, ok := r.goTypes[t.GoType]; ok {
+		r.goTypes[t.GoType] = nil
+	} else {
+		r.goTypes[t.GoType] = t
+	}
+	return t, nil
+}
+
+// MustRegister is Register but panics on error, for declaring event types in package
+// level variables.
+func (r *Registry) MustRegister(name, version string, example interface{}, schema string) *EventType {
+	t, err := r.Register(name, version, example, schema)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Lookup the event type by name
+func (r *Registry) Lookup(name string) (*EventType, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if t, ok := r.names[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w with name %q", ErrUnknownType, name)
+}
+
+// TypeOf returns the event type registered for the Go type of v, pointers are followed
+// so v can be a value or a pointer to one.
+func (r *Registry) TypeOf(v interface{}) (*EventType, error) {
+	goType := indirect(reflect.TypeOf(v))
+
+	r.RLock()
+	defer r.RUnlock()
+	t, ok := r.goTypes[goType]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w for go type %s", ErrUnknownType, goType)
+	case t == nil:
+		return nil, fmt.Errorf("%w %s", ErrAmbiguousType, goType)
+	default:
+		return t, nil
+	}
+}
+
+// Types returns the registered event types sorted by name
+func (r *Registry) Types() []*EventType {
+	r.RLock()
+	defer r.RUnlock()
+	types := make([]*EventType, 0, len(r.names))
+	for _, t := range r.names {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// Encode v with the codec and stamp the event with the type registered for v
+func (r *Registry) Encode(c codec.Codec, event *ensign.Event, v interface{}) error {
+	t, err := r.TypeOf(v)
+	if err != nil {
+		return err
+	}
+	return t.Encode(c, event, v)
+}
+
+// Decode the event into v after checking it against the type registered for v
+func (r *Registry) Decode(event *ensign.Event, v interface{}) error {
+	t, err := r.TypeOf(v)
+	if err != nil {
+		return err
+	}
+	return t.Decode(event, v)
+}
+
+// Register an event type with the default registry
+func Register(name, version string, example interface{}, schema string) (*EventType, error) {
+	return Default.Register(name, version, example, schema)
+}
+
+// MustRegister an event type with the default registry
+func MustRegister(name, version string, example interface{}, schema string) *EventType {
+	return Default.MustRegister(name, version, example, schema)
+}
+
+// Lookup the event type by name in the default registry
+func Lookup(name string) (*EventType, error) {
+	return Default.Lookup(name)
+}
+
+// TypeOf returns the event type registered for v in the default registry
+func TypeOf(v interface{}) (*EventType, error) {
+	return Default.TypeOf(v)
+}
+
+// Encode v with the codec and stamp the event with the type in the default registry
+func Encode(c codec.Codec, event *ensign.Event, v interface{}) error {
+	return Default.Encode(c, event, v)
+}
+
+// Decode the event into v after checking it against the type in the default registry
+func Decode(event *ensign.Event, v interface{}) error {
+	return Default.Decode(event, v)
+}
+
+// indirect follows pointer types to the type they point to
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,198 @@
+package types
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+type quote struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+	Venue  string  `json:"venue,omitempty"`
+}
+
+type quoteV2 struct {
+	Ticker string `json:"ticker"`
+	Cents  int64  `json:"cents"`
+}
+
+// quoteEvent returns an event with the quote encoded as JSON and stamped with the type
+func quoteEvent(t *testing.T, name string, major, minor uint32, v interface{}) *ensign.Event {
+	event := &ensign.Event{}
+	if err := codec.Encode(codec.JSON, event, v); err != nil {
+		t.Fatalf("could not encode event: %s", err)
+	}
+	event.Type = &api.Type{Name: name, MajorVersion: major, MinorVersion: minor}
+	return event
+}
+
+func TestCheck(t *testing.T) {
+	registry := NewRegistry()
+	quotes := registry.MustRegister("Quote", "1.2.0", quote{}, "")
+
+	testCases := []struct {
+		name     string
+		incoming *api.Type
+		err      error
+	}{
+		{"same version", &api.Type{Name: "Quote", MajorVersion: 1, MinorVersion: 2}, nil},
+		{"newer patch", &api.Type{Name: "Quote", MajorVersion: 1, MinorVersion: 2, PatchVersion: 7}, nil},
+		{"newer minor", &api.Type{Name: "Quote", MajorVersion: 1, MinorVersion: 3}, nil},
+		{"older minor", &api.Type{Name: "Quote", MajorVersion: 1}, nil},
+		{"newer major", &api.Type{Name: "Quote", MajorVersion: 2}, ErrIncompatible},
+		{"older major", &api.Type{Name: "Quote", MinorVersion: 9}, ErrIncompatible},
+		{"wrong name", &api.Type{Name: "Trade", MajorVersion: 1, MinorVersion: 2}, ErrTypeMismatch},
+		{"no type", nil, ErrTypeMismatch},
+	}
+
+	for _, tc := range testCases {
+		if err := quotes.Check(tc.incoming); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected error %v got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	registry := NewRegistry()
+	quotes := registry.MustRegister("Quote", "1.2.0", quote{}, "")
+	expected := quote{Symbol: "AAPL", Price: 182.52, Venue: "XNAS"}
+
+	testCases := []struct {
+		name         string
+		typeName     string
+		major, minor uint32
+		err          error
+	}{
+		{"same version", "Quote", 1, 2, nil},
+		{"newer minor", "Quote", 1, 5, nil},
+		{"older minor", "Quote", 1, 0, nil},
+		{"other major", "Quote", 2, 0, ErrIncompatible},
+		{"wrong name", "Trade", 1, 2, ErrTypeMismatch},
+	}
+
+	for _, tc := range testCases {
+		var actual quote
+		err := registry.Decode(quoteEvent(t, tc.typeName, tc.major, tc.minor, expected), &actual)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected error %v got %v", tc.name, tc.err, err)
+			continue
+		}
+
+		if tc.err == nil && actual != expected {
+			t.Errorf("%s: expected %+v got %+v", tc.name, expected, actual)
+		}
+	}
+
+	// Events without a type are never decoded
+	event := quoteEvent(t, "Quote", 1, 2, expected)
+	event.Type = nil
+	if err := quotes.Decode(event, &quote{}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected an event without a type to be a mismatch, got %v", err)
+	}
+}
+
+func TestDecodeAdapter(t *testing.T) {
+	registry := NewRegistry()
+	quotes := registry.MustRegister("Quote", "1.2.0", quote{}, "")
+	quotes.Adapt(2, func(event *ensign.Event, v interface{}) error {
+		var q quoteV2
+		if err := codec.Decode(event, &q); err != nil {
+			return err
+		}
+		*v.(*quote) = quote{Symbol: q.Ticker, Price: float64(q.Cents) / 100}
+		return nil
+	})
+
+	var actual quote
+	if err := quotes.Decode(quoteEvent(t, "Quote", 2, 0, quoteV2{Ticker: "AMZN", Cents: 12250}), &actual); err != nil {
+		t.Fatalf("expected the adapter to decode the v2 event, got %s", err)
+	}
+	if expected := (quote{Symbol: "AMZN", Price: 122.5}); actual != expected {
+		t.Errorf("expected %+v got %+v", expected, actual)
+	}
+
+	// Only the major versions with adapters are decoded
+	if err := quotes.Decode(quoteEvent(t, "Quote", 3, 0, quoteV2{}), &actual); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("expected a major version without an adapter to be incompatible, got %v", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	registry := NewRegistry()
+	registry.MustRegister("Quote", "1.2.0", quote{}, "")
+
+	event := &ensign.Event{}
+	if err := registry.Encode(nil, event, &quote{Symbol: "SNAP", Price: 10.01}); err != nil {
+		t.Fatalf("could not encode the quote: %s", err)
+	}
+
+	if event.Type == nil || event.Type.Name != "Quote" || VersionOf(event.Type) != (Version{1, 2, 0}) {
+		t.Errorf("expected the event to be stamped with Quote v1.2.0, got %v", event.Type)
+	}
+	if event.Mimetype != codec.JSON.Mimetype() {
+		t.Errorf("expected the default codec to be JSON, got %s", event.Mimetype)
+	}
+
+	if err := registry.Encode(nil, event, quoteV2{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected encoding an unregistered type to fail, got %v", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registry := NewRegistry()
+	if _, err := registry.Register("Quote", "1.2.0", quote{}, ""); err != nil {
+		t.Fatalf("could not register the quote: %s", err)
+	}
+
+	if _, err := registry.Register("Quote", "2.0.0", quoteV2{}, ""); !errors.Is(err, ErrAlreadyRegistered) {
+		t.Errorf("expected registering a name twice to fail, got %v", err)
+	}
+	if _, err := registry.Register("Bad", "one", quote{}, ""); err == nil {
+		t.Error("expected an invalid version to fail")
+	}
+	if _, err := registry.Register("", "1.0.0", quote{}, ""); err == nil {
+		t.Error("expected an empty name to fail")
+	}
+	if _, err := registry.Register("Nil", "1.0.0", nil, ""); err == nil {
+		t.Error("expected a nil example to fail")
+	}
+
+	if _, err := registry.Lookup("Trade"); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected an unknown name to fail, got %v", err)
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	registry := NewRegistry()
+	quotes := registry.MustRegister("Quote", "1.2.0", quote{}, "")
+
+	// Values and pointers resolve to the same type
+	for _, v := range []interface{}{quote{}, &quote{}} {
+		if actual, err := registry.TypeOf(v); err != nil || actual != quotes {
+			t.Errorf("expected %T to resolve to %s, got %v (%v)", v, quotes, actual, err)
+		}
+	}
+
+	if _, err := registry.TypeOf(quoteV2{}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("expected an unregistered type to fail, got %v", err)
+	}
+
+	// Once two event types share a Go type it can't be inferred from the value, but the
+	// event types can still be looked up and used directly
+	registry.MustRegister("QuoteRequest", "1.0.0", quoteV2{}, "")
+	replies := registry.MustRegister("QuoteReply", "1.0.0", quoteV2{}, "")
+
+	if _, err := registry.TypeOf(&quoteV2{}); !errors.Is(err, ErrAmbiguousType) {
+		t.Errorf("expected a shared go type to be ambiguous, got %v", err)
+	}
+	if err := registry.Encode(nil, &ensign.Event{}, quoteV2{}); !errors.Is(err, ErrAmbiguousType) {
+		t.Errorf("expected encoding a shared go type to be ambiguous, got %v", err)
+	}
+	if err := replies.Encode(nil, &ensign.Event{}, quoteV2{}); err != nil {
+		t.Errorf("expected encoding with the event type to succeed, got %s", err)
+	}
+}
@@ -0,0 +1,178 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// Version is the semantic version of an event type. The major version changes when the
+// payload changes in a way that older consumers can't decode (fields are removed,
+// renamed or change type), the minor version when fields are added and the patch
+// version for changes that don't affect the payload, e.g. documentation.
+type Version struct {
+	Major uint32
+	Minor uint32
+	Patch uint32
+}
+
+// ParseVersion parses a version such as "1.2.0", a leading v is allowed and missing
+// minor and patch versions are zero.
+func ParseVersion(s string) (v Version, err error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "v"), ".")
+	if len(parts) > 3 || parts[0] == "" {
+		return Version{}, fmt.Errorf("invalid version %q: use major.minor.patch", s)
+	}
+
+	fields := []*uint32{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		var n uint64
+		if n, err = strconv.ParseUint(part, 10, 32); err != nil {
+			return Version{}, fmt.Errorf("invalid version %q: use major.minor.patch", s)
+		}
+		*fields[i] = uint32(n)
+	}
+	return v, nil
+}
+
+// VersionOf returns the version of an Ensign event type
+func VersionOf(t *api.Type) Version {
+	if t == nil {
+		return Version{}
+	}
+	return Version{Major: t.MajorVersion, Minor: t.MinorVersion, Patch: t.PatchVersion}
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1 if v is older than o, 0 if they are the same and 1 if v is newer
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.Major != o.Major:
+		return cmp(v.Major, o.Major)
+	case v.Minor != o.Minor:
+		return cmp(v.Minor, o.Minor)
+	default:
+		return cmp(v.Patch, o.Patch)
+	}
+}
+
+// Compatible returns true if events published with the incoming version can be decoded
+// by a consumer that registered the current version. Versions are compatible when they
+// have the same major version: a newer minor version only adds fields, which decoders
+// ignore, and an older minor version leaves the new fields at their zero values.
+func Compatible(current, incoming Version) bool {
+	return current.Major == incoming.Major
+}
+
+func cmp(a, b uint32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Range is a set of version constraints that must all be satisfied, parsed by ParseRange
+type Range []constraint
+
+type constraint struct {
+	op      string
+	version Version
+}
+
+// ParseRange parses a version range of space separated constraints, e.g. ">=1.2.0 <3".
+// A constraint is a version with one of the >=, >, <=, < or = operators (a version
+// without an operator must match exactly), a major version such as "1" or "1.x" for any
+// version with that major version, a caret version such as "^1.2.0" for that version or
+// any newer version with the same major version, or "*" for any version.
+func ParseRange(s string) (r Range, err error) {
+	for _, field := range strings.Fields(s) {
+		if field == "*" {
+			continue
+		}
+
+		// Shorthands for a major version
+		if major := strings.TrimSuffix(strings.TrimSuffix(field, ".x"), ".*"); !strings.ContainsAny(major, ".<>=^") {
+			var v Version
+			if v, err = ParseVersion(major); err != nil {
+				return nil, err
+			}
+			r = append(r, constraint{">=", v}, constraint{"<", Version{Major: v.Major + 1}})
+			continue
+		}
+
+		if strings.HasPrefix(field, "^") {
+			var v Version
+			if v, err = ParseVersion(field[1:]); err != nil {
+				return nil, err
+			}
+			r = append(r, constraint{">=", v}, constraint{"<", Version{Major: v.Major + 1}})
+			continue
+		}
+
+		op := "="
+		for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(field, prefix) {
+				op = prefix
+				break
+			}
+		}
+
+		var v Version
+		if v, err = ParseVersion(strings.TrimPrefix(field, op)); err != nil {
+			return nil, err
+		}
+		r = append(r, constraint{op, v})
+	}
+	return r, nil
+}
+
+// MajorRange returns the range of versions with the major version
+func MajorRange(major uint32) Range {
+	return Range{{">=", Version{Major: major}}, {"<", Version{Major: major + 1}}}
+}
+
+// Contains returns true if the version satisfies every constraint in the range
+func (r Range) Contains(v Version) bool {
+	for _, c := range r {
+		order := v.Compare(c.version)
+		var ok bool
+		switch c.op {
+		case ">=":
+			ok = order >= 0
+		case ">":
+			ok = order > 0
+		case "<=":
+			ok = order <= 0
+		case "<":
+			ok = order < 0
+		default:
+			ok = order == 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Range) String() string {
+	if len(r) == 0 {
+		return "*"
+	}
+
+	parts := make([]string, 0, len(r))
+	for _, c := range r {
+		parts = append(parts, c.op+c.version.String())
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,135 @@
+package types
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected Version
+		valid    bool
+	}{
+		{"1.2.3", Version{1, 2, 3}, true},
+		{"v1.2.3", Version{1, 2, 3}, true},
+		{" 2.0.1 ", Version{2, 0, 1}, true},
+		{"1.2", Version{1, 2, 0}, true},
+		{"3", Version{3, 0, 0}, true},
+		{"0.0.0", Version{}, true},
+		{"", Version{}, false},
+		{"v", Version{}, false},
+		{"1.2.3.4", Version{}, false},
+		{"1..3", Version{}, false},
+		{"1.2.", Version{}, false},
+		{"1.x", Version{}, false},
+		{"-1.0.0", Version{}, false},
+		{"1.2.3-beta", Version{}, false},
+		{"4294967296.0.0", Version{}, false},
+	}
+
+	for _, tc := range testCases {
+		actual, err := ParseVersion(tc.in)
+		switch {
+		case tc.valid && err != nil:
+			t.Errorf("%q: unexpected error %s", tc.in, err)
+		case !tc.valid && err == nil:
+			t.Errorf("%q: expected an error, got %s", tc.in, actual)
+		case actual != tc.expected:
+			t.Errorf("%q: expected %s got %s", tc.in, tc.expected, actual)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	current := Version{1, 2, 0}
+	testCases := []struct {
+		incoming Version
+		expected bool
+	}{
+		{Version{1, 2, 0}, true},
+		{Version{1, 2, 9}, true},
+		{Version{1, 9, 0}, true},
+		{Version{1, 0, 0}, true},
+		{Version{2, 0, 0}, false},
+		{Version{0, 9, 0}, false},
+	}
+
+	for _, tc := range testCases {
+		if actual := Compatible(current, tc.incoming); actual != tc.expected {
+			t.Errorf("v%s reading v%s: expected compatible %t", current, tc.incoming, tc.expected)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	testCases := []struct {
+		a, b     Version
+		expected int
+	}{
+		{Version{1, 2, 3}, Version{1, 2, 3}, 0},
+		{Version{1, 2, 3}, Version{1, 2, 4}, -1},
+		{Version{1, 3, 0}, Version{1, 2, 9}, 1},
+		{Version{1, 9, 9}, Version{2, 0, 0}, -1},
+		{Version{10, 0, 0}, Version{9, 0, 0}, 1},
+	}
+
+	for _, tc := range testCases {
+		if actual := tc.a.Compare(tc.b); actual != tc.expected {
+			t.Errorf("%s compared to %s: expected %d got %d", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		in       string
+		contains []Version
+		excludes []Version
+	}{
+		{"*", []Version{{}, {1, 2, 3}, {99, 0, 0}}, nil},
+		{"", []Version{{}, {1, 2, 3}}, nil},
+		{"1", []Version{{1, 0, 0}, {1, 9, 9}}, []Version{{0, 9, 0}, {2, 0, 0}}},
+		{"1.x", []Version{{1, 0, 0}, {1, 9, 9}}, []Version{{0, 9, 0}, {2, 0, 0}}},
+		{"1.*", []Version{{1, 0, 0}, {1, 9, 9}}, []Version{{2, 0, 0}}},
+		{"^1.2.0", []Version{{1, 2, 0}, {1, 5, 1}}, []Version{{1, 1, 9}, {2, 0, 0}}},
+		{">=1.2 <3", []Version{{1, 2, 0}, {2, 9, 9}}, []Version{{1, 1, 0}, {3, 0, 0}}},
+		{">1.2.0", []Version{{1, 2, 1}, {2, 0, 0}}, []Version{{1, 2, 0}}},
+		{"<=2.1.0", []Version{{2, 1, 0}, {0, 1, 0}}, []Version{{2, 1, 1}}},
+		{"=1.2.3", []Version{{1, 2, 3}}, []Version{{1, 2, 4}, {1, 2, 2}}},
+		{"1.2.3", []Version{{1, 2, 3}}, []Version{{1, 2, 4}, {1, 3, 0}}},
+		{"v1.2.3", []Version{{1, 2, 3}}, []Version{{1, 2, 4}}},
+	}
+
+	for _, tc := range testCases {
+		r, err := ParseRange(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.in, err)
+			continue
+		}
+
+		for _, v := range tc.contains {
+			if !r.Contains(v) {
+				t.Errorf("%q (%s): expected to contain %s", tc.in, r, v)
+			}
+		}
+		for _, v := range tc.excludes {
+			if r.Contains(v) {
+				t.Errorf("%q (%s): expected not to contain %s", tc.in, r, v)
+			}
+		}
+	}
+
+	for _, in := range []string{"one", ">=x", "^", "<1.2.3.4", "1.2.3 >=two"} {
+		if _, err := ParseRange(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestMajorRange(t *testing.T) {
+	r := MajorRange(2)
+	if !r.Contains(Version{2, 0, 0}) || !r.Contains(Version{2, 7, 1}) {
+		t.Errorf("expected %s to contain major version 2", r)
+	}
+	if r.Contains(Version{1, 9, 9}) || r.Contains(Version{3, 0, 0}) {
+		t.Errorf("expected %s to only contain major version 2", r)
+	}
+}
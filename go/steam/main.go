@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/http"
+
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
 )
 
 type SteamApps struct {
@@ -60,29 +63,45 @@ type Author struct {
 }
 
 func main() {
+	// Pick the log output with -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	logs := logger.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	log := logs.Setup()
+
 	var err error
 	var response *http.Response
 	if response, err = http.Get("https://api.steampowered.com/ISteamApps/GetAppList/v2/"); err != nil {
-		fmt.Println(err)
-		return
+		logger.Fatal("could not get the steam app list", err)
 	}
 	defer response.Body.Close()
 
 	var apps SteamApps
-	if response.StatusCode != 200 {
-		fmt.Println("status code", response.StatusCode)
-		return
+	if response.StatusCode != http.StatusOK {
+		logger.Fatal("could not get the steam app list", fmt.Errorf("unexpected status code %d", response.StatusCode))
+	}
+	if err = json.NewDecoder(response.Body).Decode(&apps); err != nil {
+		logger.Fatal("could not decode the steam app list", err)
 	}
-	json.NewDecoder(response.Body).Decode(&apps)
+	log.Info("fetched steam apps", "apps", len(apps.AppList.Apps))
 
 	var reviews AppReviews
 	url := "https://store.steampowered.com/appreviews/413150?json=1'"
 	if response, err = http.Get(url); err != nil {
-		fmt.Println(err)
-		return
+		logger.Fatal("could not get the app reviews", err, "url", url)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		logger.Fatal("could not get the app reviews", fmt.Errorf("unexpected status code %d", response.StatusCode), "url", url)
+	}
+	if err = json.NewDecoder(response.Body).Decode(&reviews); err != nil {
+		logger.Fatal("could not decode the app reviews", err, "url", url)
 	}
-	json.NewDecoder(response.Body).Decode(&reviews)
 
-	fmt.Println(len(reviews.Reviews))
-	fmt.Println(reviews.Reviews[0].Review)
+	log.Info("fetched app reviews", "reviews", len(reviews.Reviews))
+	if len(reviews.Reviews) == 0 {
+		log.Warn("the app has no reviews", "url", url)
+		return
+	}
+	log.Info("first review", "id", reviews.Reviews[0].ID, "review", reviews.Reviews[0].Review)
 }
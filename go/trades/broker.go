@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Side of an order or fill
+type Side string
+
+const (
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+// Order is emitted by a strategy and filled by the simulated broker at the price of the
+// next trade for that symbol.
+type Order struct {
+	Symbol   string    `json:"symbol"`
+	Side     Side      `json:"side"`
+	Quantity float64   `json:"quantity"`
+	Created  time.Time `json:"created"`
+}
+
+// Fill is published when the simulated broker executes an order
+type Fill struct {
+	Symbol   string    `json:"symbol"`
+	Side     Side      `json:"side"`
+	Quantity float64   `json:"quantity"`
+	Price    float64   `json:"price"`
+	Fee      float64   `json:"fee"`
+	Filled   time.Time `json:"filled"`
+}
+
+// PositionUpdate is published after every fill with the new state of the position
+type PositionUpdate struct {
+	Symbol      string    `json:"symbol"`
+	Quantity    float64   `json:"quantity"`
+	AvgCost     float64   `json:"avg_cost"`
+	RealizedPnL float64   `json:"realized_pnl"`
+	Cash        float64   `json:"cash"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Position is the simulated holding for a single symbol
+type Position struct {
+	Quantity    float64
+	AvgCost     float64
+	RealizedPnL float64
+}
+
+// SimBroker fills orders against the trade stream with configurable slippage and fees
+// and keeps track of cash, positions and the equity curve for the final report. If
+// OnFill is set, it is called after every fill so that the runner can publish events.
+type SimBroker struct {
+	Cash        float64
+	SlippageBps float64 // the price moves against the order by this many basis points
+	FeeBps      float64 // fee charged as basis points of the notional value of the fill
+	FeePerOrder float64 // flat fee charged for every fill
+	OnFill      func(*Fill, *PositionUpdate)
+
+	startingCash float64
+	positions    map[string]*Position
+	lastPrices   map[string]float64
+	pending      []*Order
+	fills        int
+	fees         float64
+	peak         float64
+	maxDrawdown  float64
+}
+
+func NewSimBroker(cash, slippageBps, feeBps, feePerOrder float64) *SimBroker {
+	return &SimBroker{
+		Cash:         cash,
+		SlippageBps:  slippageBps,
+		FeeBps:       feeBps,
+		FeePerOrder:  feePerOrder,
+		startingCash: cash,
+		positions:    make(map[string]*Position),
+		lastPrices:   make(map[string]float64),
+		peak:         cash,
+	}
+}
+
+// Position returns the current position for the symbol (zero if there is none)
+func (b *SimBroker) Position(symbol string) Position {
+	if pos, ok := b.positions[symbol]; ok {
+		return *pos
+	}
+	return Position{}
+}
+
+// Submit queues an order to be filled on the next trade for its symbol
+func (b *SimBroker) Submit(order *Order) {
+	if order.Quantity <= 0 {
+		return
+	}
+	b.pending = append(b.pending, order)
+}
+
+// OnTrade fills any pending orders for the trade's symbol and marks the portfolio to
+// market so the drawdown can be tracked.
+func (b *SimBroker) OnTrade(trade Data) {
+	b.lastPrices[trade.Symbol] = trade.Price
+	ts := time.UnixMilli(int64(trade.Timestamp))
+
+	pending := b.pending[:0]
+	for _, order := range b.pending {
+		if order.Symbol != trade.Symbol {
+			pending = append(pending, order)
+			continue
+		}
+		b.fill(order, trade.Price, ts)
+	}
+	b.pending = pending
+
+	equity := b.Equity()
+	if equity > b.peak {
+		b.peak = equity
+	}
+	if drawdown := (b.peak - equity) / b.peak; drawdown > b.maxDrawdown {
+		b.maxDrawdown = drawdown
+	}
+}
+
+func (b *SimBroker) fill(order *Order, price float64, ts time.Time) {
+	// Slippage always moves the price against the order
+	slippage := price * b.SlippageBps / 10000
+	qty := order.Quantity
+	if order.Side == Buy {
+		price += slippage
+	} else {
+		price -= slippage
+		qty = -qty
+	}
+
+	fee := b.FeePerOrder + math.Abs(qty)*price*b.FeeBps/10000
+	b.Cash -= qty*price + fee
+	b.fees += fee
+	b.fills++
+
+	pos, ok := b.positions[order.Symbol]
+	if !ok {
+		pos = &Position{}
+		b.positions[order.Symbol] = pos
+	}
+
+	switch {
+	case pos.Quantity == 0 || (pos.Quantity > 0) == (qty > 0):
+		// Opening or adding to a position moves the average cost
+		pos.AvgCost = (pos.AvgCost*pos.Quantity + price*qty) / (pos.Quantity + qty)
+		pos.Quantity += qty
+	default:
+		// Reducing, closing or flipping a position realizes P&L on the closed quantity
+		closed := math.Min(math.Abs(qty), math.Abs(pos.Quantity))
+		direction := math.Copysign(1, pos.Quantity)
+		pos.RealizedPnL += closed * (price - pos.AvgCost) * direction
+		pos.Quantity += qty
+		if math.Abs(qty) > closed {
+			pos.AvgCost = price
+		} else if pos.Quantity == 0 {
+			pos.AvgCost = 0
+		}
+	}
+	pos.RealizedPnL -= fee
+
+	if b.OnFill != nil {
+		b.OnFill(
+			&Fill{Symbol: order.Symbol, Side: order.Side, Quantity: order.Quantity, Price: price, Fee: fee, Filled: ts},
+			&PositionUpdate{Symbol: order.Symbol, Quantity: pos.Quantity, AvgCost: pos.AvgCost, RealizedPnL: pos.RealizedPnL, Cash: b.Cash, Updated: ts},
+		)
+	}
+}
+
+// Equity is the cash plus the market value of all positions at the last traded prices
+func (b *SimBroker) Equity() float64 {
+	equity := b.Cash
+	for symbol, pos := range b.positions {
+		equity += pos.Quantity * b.lastPrices[symbol]
+	}
+	return equity
+}
+
+// Report prints the final P&L and drawdown of the simulation
+func (b *SimBroker) Report() {
+	equity := b.Equity()
+	fmt.Println("=== strategy report ===")
+	fmt.Printf("starting cash:  %.2f\n", b.startingCash)
+	fmt.Printf("ending equity:  %.2f\n", equity)
+	fmt.Printf("total P&L:      %.2f (%.2f%%)\n", equity-b.startingCash, 100*(equity-b.startingCash)/b.startingCash)
+	fmt.Printf("fees paid:      %.2f over %d fills\n", b.fees, b.fills)
+	fmt.Printf("max drawdown:   %.2f%%\n", 100*b.maxDrawdown)
+	for symbol, pos := range b.positions {
+		unrealized := pos.Quantity * (b.lastPrices[symbol] - pos.AvgCost)
+		fmt.Printf("  %-6s qty=%-8.0f avg=%-10.2f realized=%-10.2f unrealized=%.2f\n", symbol, pos.Quantity, pos.AvgCost, pos.RealizedPnL, unrealized)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// tradeAt returns a trade for the symbol at the price, a second after the previous one
+func tradeAt(symbol string, price float64, i int) Data {
+	ts := time.Date(2023, 6, 1, 14, 30, 0, 0, time.UTC).Add(time.Duration(i) * time.Second)
+	return Data{Symbol: symbol, Price: price, Volume: 100, Timestamp: uint64(ts.UnixMilli())}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSimBrokerPnL(t *testing.T) {
+	broker := NewSimBroker(100000, 0, 0, 0)
+
+	testCases := []struct {
+		name     string
+		side     Side
+		qty      float64
+		price    float64
+		position Position
+		cash     float64
+	}{
+		{"open long", Buy, 100, 10, Position{Quantity: 100, AvgCost: 10}, 99000},
+		{"add to long", Buy, 100, 12, Position{Quantity: 200, AvgCost: 11}, 97800},
+		{"reduce long", Sell, 50, 13, Position{Quantity: 150, AvgCost: 11, RealizedPnL: 100}, 98450},
+		{"flip to short", Sell, 200, 9, Position{Quantity: -50, AvgCost: 9, RealizedPnL: -200}, 100250},
+		{"close short", Buy, 50, 8, Position{Quantity: 0, AvgCost: 0, RealizedPnL: -150}, 99850},
+	}
+
+	for i, tc := range testCases {
+		broker.Submit(&Order{Symbol: "AAPL", Side: tc.side, Quantity: tc.qty})
+		broker.OnTrade(tradeAt("AAPL", tc.price, i))
+
+		pos := broker.Position("AAPL")
+		if !approx(pos.Quantity, tc.position.Quantity) || !approx(pos.AvgCost, tc.position.AvgCost) || !approx(pos.RealizedPnL, tc.position.RealizedPnL) {
+			t.Errorf("%s: expected position %+v got %+v", tc.name, tc.position, pos)
+		}
+		if !approx(broker.Cash, tc.cash) {
+			t.Errorf("%s: expected cash %.2f got %.2f", tc.name, tc.cash, broker.Cash)
+		}
+	}
+
+	// Without fees the realized P&L of a closed position is the change in equity
+	if equity := broker.Equity(); !approx(equity-100000, broker.Position("AAPL").RealizedPnL) {
+		t.Errorf("expected the equity to change by the realized P&L, got equity %.2f", equity)
+	}
+}
+
+func TestSimBrokerFees(t *testing.T) {
+	// 1% slippage, 10 bps fees and $1 per fill
+	broker := NewSimBroker(10000, 100, 10, 1)
+
+	var fills []*Fill
+	var updates []*PositionUpdate
+	broker.OnFill = func(fill *Fill, update *PositionUpdate) {
+		fills = append(fills, fill)
+		updates = append(updates, update)
+	}
+
+	// Slippage moves the price against the order: buys fill higher and sells lower
+	broker.Submit(&Order{Symbol: "AMZN", Side: Buy, Quantity: 10})
+	broker.OnTrade(tradeAt("AMZN", 100, 0))
+	broker.Submit(&Order{Symbol: "AMZN", Side: Sell, Quantity: 10})
+	broker.OnTrade(tradeAt("AMZN", 100, 1))
+
+	if len(fills) != 2 || len(updates) != 2 {
+		t.Fatalf("expected 2 fills and position updates got %d and %d", len(fills), len(updates))
+	}
+
+	expected := []struct{ price, fee float64 }{{101, 1 + 1010*0.001}, {99, 1 + 990*0.001}}
+	for i, fill := range fills {
+		if !approx(fill.Price, expected[i].price) || !approx(fill.Fee, expected[i].fee) {
+			t.Errorf("fill %d: expected price %.2f and fee %.2f got %.2f and %.2f", i, expected[i].price, expected[i].fee, fill.Price, fill.Fee)
+		}
+	}
+
+	// The round trip loses the slippage on both fills and both fees
+	if pnl := broker.Position("AMZN").RealizedPnL; !approx(pnl, -20-2.01-1.99) {
+		t.Errorf("expected realized P&L of -24.00 got %.2f", pnl)
+	}
+	if !approx(broker.Cash, 9976) || !approx(updates[1].Cash, 9976) {
+		t.Errorf("expected cash of 9976.00 got %.2f", broker.Cash)
+	}
+	if broker.fills != 2 || !approx(broker.fees, 4) {
+		t.Errorf("expected 2 fills and 4.00 fees got %d and %.2f", broker.fills, broker.fees)
+	}
+}
+
+func TestSimBrokerOrders(t *testing.T) {
+	broker := NewSimBroker(10000, 0, 0, 0)
+
+	// Orders without a quantity are ignored and orders wait for a trade in their symbol
+	broker.Submit(&Order{Symbol: "PCG", Side: Buy, Quantity: 0})
+	broker.Submit(&Order{Symbol: "SNAP", Side: Buy, Quantity: 10})
+	broker.OnTrade(tradeAt("PCG", 15, 0))
+
+	if pos := broker.Position("PCG"); pos.Quantity != 0 {
+		t.Errorf("expected the empty order to be ignored, got %+v", pos)
+	}
+	if pos := broker.Position("SNAP"); pos.Quantity != 0 || len(broker.pending) != 1 {
+		t.Errorf("expected the SNAP order to be pending, got %+v", pos)
+	}
+
+	broker.OnTrade(tradeAt("SNAP", 10, 1))
+	if pos := broker.Position("SNAP"); pos.Quantity != 10 || len(broker.pending) != 0 {
+		t.Errorf("expected the SNAP order to be filled on the SNAP trade, got %+v", pos)
+	}
+}
+
+func TestSimBrokerDrawdown(t *testing.T) {
+	broker := NewSimBroker(1000, 0, 0, 0)
+	broker.Submit(&Order{Symbol: "AAPL", Side: Buy, Quantity: 10})
+
+	// Equity: 1000, 900 (10% drawdown), 1100 (new peak), 1050 (4.5% drawdown)
+	for i, price := range []float64{50, 40, 60, 55} {
+		broker.OnTrade(tradeAt("AAPL", price, i))
+	}
+
+	if !approx(broker.Equity(), 1050) {
+		t.Errorf("expected equity of 1050.00 got %.2f", broker.Equity())
+	}
+	if !approx(broker.peak, 1100) || !approx(broker.maxDrawdown, 0.1) {
+		t.Errorf("expected a peak of 1100.00 and max drawdown of 10%% got %.2f and %.2f%%", broker.peak, 100*broker.maxDrawdown)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConditionFlag describes how a trade condition affects the way a print should be
+// treated when computing bars and indicators. A single condition may set several flags.
+type ConditionFlag uint8
+
+const (
+	OddLot              ConditionFlag = 1 << iota // the trade was for less than a round lot (usually 100 shares)
+	OutOfSequence                                 // the trade was reported late or out of order
+	ExcludedFromLast                              // the trade must not update the last sale price
+	ExcludedFromHighLow                           // the trade must not update the high/low of the day
+	ExtendedHours                                 // the trade happened outside of the regular session
+)
+
+// conditionFlagNames maps the names accepted on the command line to the flags above
+var conditionFlagNames = map[string]ConditionFlag{
+	"odd-lot":                OddLot,
+	"out-of-sequence":        OutOfSequence,
+	"excluded-from-last":     ExcludedFromLast,
+	"excluded-from-high-low": ExcludedFromHighLow,
+	"extended-hours":         ExtendedHours,
+}
+
+// String returns the comma separated names of the flags that are set
+func (f ConditionFlag) String() string {
+	names := make([]string, 0, len(conditionFlagNames))
+	for name, flag := range conditionFlagNames {
+		if f&flag != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Condition is the decoded meaning of a single trade condition code
+type Condition struct {
+	Code    string
+	Meaning string
+	Flags   ConditionFlag
+}
+
+// Conditions is the lookup table for the US equities trade condition codes that come
+// back from Finnhub in Data.Conditions. The numbering follows the consolidated UTP/CTA
+// condition list that Finnhub references in its websocket documentation; if Finnhub
+// adds new codes, add them here and unknown codes will be reported until you do.
+var Conditions = map[string]Condition{
+	"0":  {"0", "Regular Sale", 0},
+	"1":  {"1", "Acquisition", 0},
+	"2":  {"2", "Average Price Trade", ExcludedFromLast | ExcludedFromHighLow},
+	"3":  {"3", "Automatic Execution", 0},
+	"4":  {"4", "Bunched Trade", 0},
+	"5":  {"5", "Bunched Sold Trade", OutOfSequence},
+	"6":  {"6", "CAP Election", 0},
+	"7":  {"7", "Cash Sale", ExcludedFromLast | ExcludedFromHighLow},
+	"8":  {"8", "Closing Prints", 0},
+	"9":  {"9", "Cross Trade", 0},
+	"10": {"10", "Derivatively Priced", ExcludedFromLast},
+	"11": {"11", "Distribution", 0},
+	"12": {"12", "Form T (Extended Hours)", ExtendedHours | ExcludedFromLast | ExcludedFromHighLow},
+	"13": {"13", "Extended Hours Sold (Out of Sequence)", ExtendedHours | OutOfSequence | ExcludedFromLast | ExcludedFromHighLow},
+	"14": {"14", "Intermarket Sweep", 0},
+	"15": {"15", "Market Center Official Close", ExcludedFromLast | ExcludedFromHighLow},
+	"16": {"16", "Market Center Official Open", ExcludedFromLast | ExcludedFromHighLow},
+	"17": {"17", "Market Center Opening Trade", 0},
+	"18": {"18", "Market Center Reopening Trade", 0},
+	"19": {"19", "Market Center Closing Trade", 0},
+	"20": {"20", "Next Day", ExcludedFromLast | ExcludedFromHighLow},
+	"21": {"21", "Price Variation Trade", ExcludedFromLast | ExcludedFromHighLow},
+	"22": {"22", "Prior Reference Price", OutOfSequence | ExcludedFromLast},
+	"23": {"23", "Rule 155 Trade (AMEX)", 0},
+	"24": {"24", "Rule 127 Trade (NYSE)", 0},
+	"25": {"25", "Opening Prints", 0},
+	"27": {"27", "Stopped Stock (Regular Trade)", 0},
+	"28": {"28", "Re-Opening Prints", 0},
+	"29": {"29", "Seller", ExcludedFromLast | ExcludedFromHighLow},
+	"30": {"30", "Sold Last", OutOfSequence},
+	"31": {"31", "Sold Last and Stopped Stock", OutOfSequence},
+	"32": {"32", "Sold (Out of Sequence)", OutOfSequence | ExcludedFromLast},
+	"33": {"33", "Sold and Stopped Stock (Out of Sequence)", OutOfSequence | ExcludedFromLast},
+	"34": {"34", "Split Trade", 0},
+	"35": {"35", "Stock Option", 0},
+	"36": {"36", "Yellow Flag Regular Trade", 0},
+	"37": {"37", "Odd Lot Trade", OddLot | ExcludedFromLast | ExcludedFromHighLow},
+	"38": {"38", "Corrected Consolidated Close", ExcludedFromLast | ExcludedFromHighLow},
+	"41": {"41", "Trade Thru Exempt", 0},
+	"52": {"52", "Contingent Trade", ExcludedFromLast | ExcludedFromHighLow},
+	"53": {"53", "Qualified Contingent Trade", ExcludedFromLast | ExcludedFromHighLow},
+}
+
+// DecodeConditions looks up each of the condition codes on a trade, returning the
+// decoded conditions, the union of all of their flags, and any codes that are unknown.
+func DecodeConditions(codes []string) (conds []Condition, flags ConditionFlag, unknown []string) {
+	for _, code := range codes {
+		cond, ok := Conditions[strings.TrimSpace(code)]
+		if !ok {
+			unknown = append(unknown, code)
+			continue
+		}
+		conds = append(conds, cond)
+		flags |= cond.Flags
+	}
+	return conds, flags, unknown
+}
+
+// ParseConditionFlags parses a comma separated list of flag names such as
+// "odd-lot,out-of-sequence" into a ConditionFlag bitmask.
+func ParseConditionFlags(s string) (flags ConditionFlag, err error) {
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		flag, ok := conditionFlagNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown condition flag %q", name)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// ConditionFilter drops or tags trades based on their condition codes. Indicators
+// computed on odd-lot and late prints are misleading, so consumers can use the filter
+// to remove those trades from the stream or to label them so they can be skipped later.
+type ConditionFilter struct {
+	Drop ConditionFlag // trades with any of these flags are removed
+	Tag  bool          // if true, the decoded condition meanings are added to each trade
+}
+
+// Apply filters the trades in the response in place, returning the number of trades
+// that were dropped.
+func (f *ConditionFilter) Apply(msg *Response) (dropped int) {
+	kept := msg.Data[:0]
+	for _, trade := range msg.Data {
+		conds, flags, unknown := DecodeConditions(trade.Conditions)
+		if flags&f.Drop != 0 {
+			dropped++
+			continue
+		}
+
+		if f.Tag {
+			trade.Tags = make([]string, 0, len(conds)+len(unknown))
+			for _, cond := range conds {
+				trade.Tags = append(trade.Tags, cond.Meaning)
+			}
+			for _, code := range unknown {
+				trade.Tags = append(trade.Tags, "Unknown Condition "+code)
+			}
+			trade.Flags = flags.String()
+		}
+		kept = append(kept, trade)
+	}
+	msg.Data = kept
+	return dropped
+}
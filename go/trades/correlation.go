@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Topic that correlation updates and spread alerts are published to
+const TradesCorrelation = "trades-correlation"
+
+// Pair of symbols whose correlation and spread are monitored
+type Pair struct {
+	A string
+	B string
+}
+
+func (p Pair) String() string {
+	return p.A + "/" + p.B
+}
+
+// ParsePairs parses pairs in the form "AAPL:AMZN,PCG:SNAP"
+func ParsePairs(s string) (pairs []Pair, err error) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("could not parse pair %q, use SYMBOL:SYMBOL", item)
+		}
+		pairs = append(pairs, Pair{A: strings.ToUpper(parts[0]), B: strings.ToUpper(parts[1])})
+	}
+	return pairs, nil
+}
+
+// CorrelationUpdate is published for every pair each time the grid advances
+type CorrelationUpdate struct {
+	Pair        string    `json:"pair"`
+	Time        time.Time `json:"time"`
+	Correlation float64   `json:"correlation"`
+	Spread      float64   `json:"spread"`
+	ZScore      float64   `json:"zscore"`
+	Alert       bool      `json:"alert"`
+}
+
+// CorrelationMonitor aligns the last traded price of each symbol onto a common time
+// grid (carrying prices forward when a symbol doesn't trade) and computes the rolling
+// Pearson correlation of log returns and the z-score of the log-price spread per pair.
+// After a gap in trading longer than the window, e.g. overnight or over a weekend, the
+// series start over from the next trades rather than sampling flat prices for the gap.
+type CorrelationMonitor struct {
+	Pairs     []Pair
+	Grid      time.Duration
+	Window    int
+	Threshold float64
+	last      map[string]float64
+	series    map[string][]float64
+	current   time.Time
+}
+
+func NewCorrelationMonitor(pairs []Pair, grid time.Duration, window int, threshold float64) *CorrelationMonitor {
+	return &CorrelationMonitor{
+		Pairs:     pairs,
+		Grid:      grid,
+		Window:    window,
+		Threshold: threshold,
+		last:      make(map[string]float64),
+		series:    make(map[string][]float64),
+	}
+}
+
+// OnTrade records the trade and returns updates for any grid points that have closed
+func (m *CorrelationMonitor) OnTrade(trade Data) (updates []*CorrelationUpdate) {
+	ts := time.UnixMilli(int64(trade.Timestamp)).Truncate(m.Grid)
+	if m.current.IsZero() {
+		m.current = ts
+	}
+
+	// Start over after a gap that the window can't span, closing the last grid point
+	// before the gap but without sampling the grid points during the gap
+	if ts.Sub(m.current) > time.Duration(m.Window)*m.Grid {
+		updates = append(updates, m.sample(m.current)...)
+		m.last = make(map[string]float64)
+		m.series = make(map[string][]float64)
+		m.current = ts
+	}
+
+	// Close every grid point before this trade, carrying the last prices forward
+	for m.current.Before(ts) {
+		updates = append(updates, m.sample(m.current)...)
+		m.current = m.current.Add(m.Grid)
+	}
+
+	m.last[trade.Symbol] = trade.Price
+	return updates
+}
+
+func (m *CorrelationMonitor) sample(ts time.Time) (updates []*CorrelationUpdate) {
+	for symbol, price := range m.last {
+		series := append(m.series[symbol], math.Log(price))
+		if len(series) > m.Window+1 {
+			series = series[1:]
+		}
+		m.series[symbol] = series
+	}
+
+	for _, pair := range m.Pairs {
+		a, b := m.series[pair.A], m.series[pair.B]
+		n := len(a)
+		if len(b) < n {
+			n = len(b)
+		}
+		if n < 3 {
+			continue
+		}
+		a, b = a[len(a)-n:], b[len(b)-n:]
+
+		spreads := make([]float64, n)
+		for i := range spreads {
+			spreads[i] = a[i] - b[i]
+		}
+
+		mean, stddev := meanStdDev(spreads)
+		update := &CorrelationUpdate{
+			Pair:        pair.String(),
+			Time:        ts,
+			Correlation: pearson(returns(a), returns(b)),
+			Spread:      spreads[n-1],
+		}
+
+		if stddev > 0 {
+			update.ZScore = (update.Spread - mean) / stddev
+		}
+		update.Alert = math.Abs(update.ZScore) > m.Threshold
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+func returns(logPrices []float64) []float64 {
+	r := make([]float64, 0, len(logPrices)-1)
+	for i := 1; i < len(logPrices); i++ {
+		r = append(r, logPrices[i]-logPrices[i-1])
+	}
+	return r
+}
+
+// pearson returns the Pearson correlation coefficient of x and y (0 if undefined)
+func pearson(x, y []float64) float64 {
+	mx, sx := meanStdDev(x)
+	my, sy := meanStdDev(y)
+	if sx == 0 || sy == 0 {
+		return 0
+	}
+
+	var cov float64
+	for i := range x {
+		cov += (x[i] - mx) * (y[i] - my)
+	}
+	return cov / float64(len(x)) / (sx * sy)
+}
+
+// Correlate consumes the Trades topic and publishes CorrelationUpdate events for each of
+// the configured pairs, alerting when the spread z-score passes the threshold.
+func Correlate(args []string) {
+	fs := flag.NewFlagSet("correlate", flag.ExitOnError)
+	pairsFlag := fs.String("pairs", "AAPL:AMZN,PCG:SNAP", "comma separated pairs of symbols to monitor, e.g. AAPL:AMZN")
+	grid := fs.Duration("grid", 5*time.Second, "interval of the common time grid that prices are aligned to")
+	window := fs.Int("window", 120, "number of grid points in the rolling window")
+	threshold := fs.Float64("threshold", 2, "alert when the absolute spread z-score is above this threshold")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades-correlate")()
+
+	// A window needs at least two returns to correlate and a grid to align them to
+	if *grid <= 0 {
+		logger.Fatal("invalid correlation grid", errors.New("-grid must be positive"), "grid", *grid)
+	}
+	if *window < 3 {
+		logger.Fatal("invalid correlation window", errors.New("-window must be at least 3 grid points"), "window", *window)
+	}
+
+	pairs, err := ParsePairs(*pairsFlag)
+	if err != nil {
+		logger.Fatal("could not parse pairs", err)
+	}
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	EnsureTopic(client, Trades)
+	EnsureTopic(client, TradesCorrelation)
+
+	sub, err := client.Subscribe(Trades)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+	}
+	defer sub.Close()
+
+	// A failed update is logged rather than retried since the monitor has already moved
+	// its windows on and would count the trades twice
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+	monitor := NewCorrelationMonitor(pairs, *grid, *window, *threshold)
+	events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, msg *Response) error {
+		log := logger.FromContext(ctx)
+		for _, trade := range msg.Data {
+			for _, update := range monitor.OnTrade(trade) {
+				if update.Alert {
+					log.Warn("spread z-score alert", "pair", update.Pair, "zscore", update.ZScore, "correlation", update.Correlation)
+				}
+
+				if err := publishJSON(ctx, client, stats, TradesCorrelation, update); err != nil {
+					log.Error("could not publish correlation update", "pair", update.Pair, "error", err)
+				}
+			}
+		}
+		return nil
+	})
+
+	if err = events.Serve(context.Background(), sub); err != nil {
+		logger.Fatal("subscriber stopped", err, logger.KeyTopic, Trades)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrelationGap(t *testing.T) {
+	monitor := NewCorrelationMonitor([]Pair{{A: "AAPL", B: "AMZN"}}, 5*time.Second, 10, 2)
+	start := time.Date(2023, 6, 2, 19, 59, 0, 0, time.UTC)
+
+	trade := func(symbol string, price float64, ts time.Time) []*CorrelationUpdate {
+		return monitor.OnTrade(Data{Symbol: symbol, Price: price, Volume: 1, Timestamp: uint64(ts.UnixMilli())})
+	}
+
+	// Trade both symbols on every grid point for a minute
+	var updates int
+	for i := 0; i < 12; i++ {
+		ts := start.Add(time.Duration(i) * 5 * time.Second)
+		updates += len(trade("AAPL", 180+float64(i%3), ts))
+		updates += len(trade("AMZN", 120+float64(i%2), ts))
+	}
+	if updates == 0 {
+		t.Fatal("expected correlation updates while both symbols trade")
+	}
+
+	// A weekend later only the grid point before the gap is closed rather than every
+	// grid point in the gap
+	monday := start.Add(62 * time.Hour)
+	if n := len(trade("AAPL", 185, monday)); n > 1 {
+		t.Fatalf("expected at most one update after the weekend gap, got %d", n)
+	}
+	if !monitor.current.Equal(monday) {
+		t.Errorf("expected the grid to jump to %s, got %s", monday, monitor.current)
+	}
+
+	// The series start over so the pair isn't updated until both symbols trade again
+	for i := 1; i <= 3; i++ {
+		if n := len(trade("AAPL", 185, monday.Add(time.Duration(i)*5*time.Second))); n != 0 {
+			t.Errorf("expected no updates before AMZN trades after the gap, got %d", n)
+		}
+	}
+
+	// Gaps shorter than the window are still carried forward one grid point at a time
+	monitor = NewCorrelationMonitor([]Pair{{A: "AAPL", B: "AMZN"}}, 5*time.Second, 10, 2)
+	trade("AAPL", 180, start)
+	trade("AMZN", 120, start)
+	trade("AAPL", 181, start.Add(30*time.Second))
+	if expected := start.Add(30 * time.Second); !monitor.current.Equal(expected) {
+		t.Errorf("expected the grid to advance to %s, got %s", expected, monitor.current)
+	}
+	if n := len(monitor.series["AMZN"]); n != 6 {
+		t.Errorf("expected AMZN to be carried forward over 6 grid points, got %d", n)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	stdSQL "database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/lib/pq"
+)
+
+// tradesSchemaAdapter inserts trades into a table with numeric and timestamp columns
+// rather than the generic payload column used by watermill-sql. Every trade has a
+// trade_key derived from its contents so that inserts are idempotent: redelivered
+// events are ignored by the ON CONFLICT clause instead of creating duplicate rows.
+type tradesSchemaAdapter struct{}
+
+func (p tradesSchemaAdapter) SchemaInitializingQueries(topic string) []string {
+	createQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id BIGSERIAL NOT NULL PRIMARY KEY,
+		trade_key CHAR(64) NOT NULL UNIQUE,
+		symbol VARCHAR(16) NOT NULL,
+		price NUMERIC(18,6) NOT NULL,
+		volume NUMERIC(20,4) NOT NULL DEFAULT 0,
+		traded_at TIMESTAMPTZ NOT NULL,
+		conditions TEXT[],
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`, topic)
+
+	indexQuery := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_symbol_traded_at_idx ON %s (symbol, traded_at);`, topic, topic)
+	return []string{createQuery, indexQuery}
+}
+
+// InsertQuery inserts all of the trades in a single batch statement; each message holds
+// a JSON array of trades produced by the batchTrades handler. Postgres refuses
+// to update the same row twice in one statement, so duplicates within the batch are
+// dropped here and duplicates of rows already in the table by the ON CONFLICT clause.
+func (p tradesSchemaAdapter) InsertQuery(topic string, msgs message.Messages) (string, []interface{}, error) {
+	const cols = 6
+	var trades []Data
+	for _, msg := range msgs {
+		batch := make([]Data, 0)
+		if err := json.Unmarshal(msg.Payload, &batch); err != nil {
+			return "", nil, err
+		}
+		trades = append(trades, batch...)
+	}
+
+	if len(trades) == 0 {
+		return "", nil, errors.New("no trades to insert")
+	}
+
+	values := make([]string, 0, len(trades))
+	args := make([]interface{}, 0, len(trades)*cols)
+	keys := make(map[string]struct{}, len(trades))
+
+	for _, trade := range trades {
+		key := TradeKey(trade)
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		keys[key] = struct{}{}
+
+		placeholders := make([]string, cols)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ",")+")")
+
+		args = append(
+			args,
+			key,
+			trade.Symbol,
+			trade.Price,
+			trade.Volume,
+			time.UnixMilli(int64(trade.Timestamp)).UTC(),
+			pq.Array(trade.Conditions),
+		)
+	}
+
+	insertQuery := fmt.Sprintf(
+		`INSERT INTO %s (trade_key, symbol, price, volume, traded_at, conditions) VALUES %s ON CONFLICT (trade_key) DO NOTHING`,
+		topic,
+		strings.Join(values, ","),
+	)
+	return insertQuery, args, nil
+}
+
+func (p tradesSchemaAdapter) SelectQuery(topic string, consumerGroup string, offsetsAdapter sql.OffsetsAdapter) (string, []interface{}) {
+	// No need to implement this method, as PostgreSQL subscriber is not used in this example.
+	return "", nil
+}
+
+func (p tradesSchemaAdapter) UnmarshalMessage(row *stdSQL.Row) (offset int, msg *message.Message, err error) {
+	return 0, nil, errors.New("not implemented")
+}
+
+// TradeKey uniquely identifies a trade by hashing the fields that Finnhub sends
+func TradeKey(trade Data) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%f|%f|%s", trade.Symbol, trade.Timestamp, trade.Price, trade.Volume, strings.Join(trade.Conditions, ";"))))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Topic that enriched trades are published to
+const TradesEnriched = "trades-enriched"
+
+// Reference is the static data about a symbol that dashboards want instead of tickers
+type Reference struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Sector   string `json:"sector"`
+	Exchange string `json:"exchange"`
+	LotSize  int    `json:"lot_size"`
+}
+
+// EnrichedTrade is a trade joined with the reference data for its symbol; Reference is
+// nil if the symbol is missing from the reference file.
+type EnrichedTrade struct {
+	Data
+	Reference *Reference `json:"reference,omitempty"`
+}
+
+// EnrichedResponse has the same shape as a Finnhub response so consumers can switch
+// between the raw and enriched topics easily.
+type EnrichedResponse struct {
+	Type string          `json:"type"`
+	Data []EnrichedTrade `json:"data"`
+}
+
+// ReferenceData holds the reference file in memory and reloads it when the file
+// changes on disk or the process receives a SIGHUP, so no restart is required.
+type ReferenceData struct {
+	sync.RWMutex
+	path     string
+	modified time.Time
+	symbols  map[string]*Reference
+	missing  map[string]uint64
+}
+
+func NewReferenceData(path string) (ref *ReferenceData, err error) {
+	ref = &ReferenceData{path: path, missing: make(map[string]uint64)}
+	if err = ref.Reload(); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Reload reads the reference file (CSV with a header or a JSON array) from disk
+func (r *ReferenceData) Reload() (err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(r.path); err != nil {
+		return err
+	}
+
+	var refs []*Reference
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".csv":
+		refs, err = readReferenceCSV(r.path)
+	case ".json":
+		var data []byte
+		if data, err = os.ReadFile(r.path); err == nil {
+			err = json.Unmarshal(data, &refs)
+		}
+	default:
+		err = fmt.Errorf("unknown reference file format %q: use .csv or .json", filepath.Ext(r.path))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	symbols := make(map[string]*Reference, len(refs))
+	for _, ref := range refs {
+		symbols[strings.ToUpper(ref.Symbol)] = ref
+	}
+
+	r.Lock()
+	r.symbols = symbols
+	r.modified = info.ModTime()
+	r.Unlock()
+
+	slog.Info("loaded reference data", "symbols", len(symbols), "path", r.path)
+	return nil
+}
+
+func readReferenceCSV(path string) (refs []*Reference, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows [][]string
+	if rows, err = csv.NewReader(f).ReadAll(); err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	cols := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := cols["symbol"]; !ok {
+		return nil, fmt.Errorf("reference csv is missing the symbol column")
+	}
+
+	for _, row := range rows[1:] {
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		ref := &Reference{
+			Symbol:   field("symbol"),
+			Name:     field("name"),
+			Sector:   field("sector"),
+			Exchange: field("exchange"),
+		}
+
+		if lot := field("lot_size"); lot != "" {
+			if ref.LotSize, err = strconv.Atoi(lot); err != nil {
+				return nil, fmt.Errorf("could not parse lot size for %s: %w", ref.Symbol, err)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// Watch reloads the reference data when the file is modified or on SIGHUP
+func (r *ReferenceData) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil {
+				continue
+			}
+
+			r.RLock()
+			modified := r.modified
+			r.RUnlock()
+
+			if !info.ModTime().After(modified) {
+				continue
+			}
+		}
+
+		if err := r.Reload(); err != nil {
+			slog.Warn("could not reload reference data, keeping the previous data", "path", r.path, "error", err)
+		}
+	}
+}
+
+// Lookup returns the reference data for the symbol or nil (and counts it) if missing
+func (r *ReferenceData) Lookup(symbol string) *Reference {
+	r.RLock()
+	ref, ok := r.symbols[strings.ToUpper(symbol)]
+	r.RUnlock()
+
+	if !ok {
+		r.Lock()
+		if r.missing[symbol] == 0 {
+			slog.Warn("no reference data for symbol", "symbol", symbol)
+		}
+		r.missing[symbol]++
+		r.Unlock()
+	}
+	return ref
+}
+
+// Missing returns the symbols without reference data and how many trades they had
+func (r *ReferenceData) Missing() string {
+	r.RLock()
+	defer r.RUnlock()
+
+	symbols := make([]string, 0, len(r.missing))
+	for symbol, count := range r.missing {
+		symbols = append(symbols, fmt.Sprintf("%s (%d trades)", symbol, count))
+	}
+	sort.Strings(symbols)
+	return strings.Join(symbols, ", ")
+}
+
+// Enrich joins the raw Trades topic with the reference data and publishes the enriched
+// trades to the trades-enriched topic, periodically reporting any missing symbols.
+func Enrich(args []string) {
+	fs := flag.NewFlagSet("enrich", flag.ExitOnError)
+	path := fs.String("reference", "reference.csv", "path to the csv or json symbol reference file")
+	interval := fs.Duration("reload", 30*time.Second, "how often to check the reference file for changes")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades-enrich")()
+
+	refs, err := NewReferenceData(*path)
+	if err != nil {
+		logger.Fatal("could not load reference data", err, "path", *path)
+	}
+	go refs.Watch(*interval)
+
+	// Report the missing symbols every reload interval
+	go func() {
+		for range time.Tick(*interval) {
+			if missing := refs.Missing(); missing != "" {
+				log.Warn("missing reference data", "symbols", missing)
+			}
+		}
+	}()
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	EnsureTopic(client, Trades)
+	EnsureTopic(client, TradesEnriched)
+
+	sub, err := client.Subscribe(Trades)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+	}
+	defer sub.Close()
+
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+	events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, msg *Response) (err error) {
+		enriched := &EnrichedResponse{Type: msg.Type, Data: make([]EnrichedTrade, 0, len(msg.Data))}
+		for _, trade := range msg.Data {
+			enriched.Data = append(enriched.Data, EnrichedTrade{Data: trade, Reference: refs.Lookup(trade.Symbol)})
+		}
+
+		// Keep the original metadata so that latency stamps survive the stage
+		e := &ensign.Event{Metadata: CopyMetadata(event)}
+
+		// Publish the enriched trades in the same format as the raw trades
+		enc, _ := codec.Default.Lookup(event.Mimetype)
+		if err = EnrichedTradesType.Encode(enc, e, enriched); err != nil {
+			return fmt.Errorf("could not encode enriched trades: %w", err)
+		}
+
+		if err = publish(ctx, client, stats, TradesEnriched, e); err != nil {
+			return fmt.Errorf("could not publish enriched trades: %w", err)
+		}
+		return nil
+	})
+
+	if err = events.Serve(context.Background(), sub); err != nil {
+		logger.Fatal("subscriber stopped", err, logger.KeyTopic, Trades)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "github.com/rotationalio/ensign-examples/go/shared/types"
+
+// Event types published by the trades stages. The Trades type was bumped to 1.1.0 when
+// the condition filter tags were added and to 1.2.0 when the market session fields were
+// added, so consumers built against any 1.x version can still read the trades topic.
+var (
+	TradesType           = types.MustRegister("Trades", "1.2.0", Response{}, "")
+	EnrichedTradesType   = types.MustRegister("EnrichedTrades", "1.0.0", EnrichedResponse{}, "")
+	QuarantinedTradeType = types.MustRegister("QuarantinedTrade", "1.0.0", QuarantinedTrade{}, "")
+	LatencyReportType    = types.MustRegister("LatencyReport", "1.0.0", LatencyReport{}, "")
+	CorrelationType      = types.MustRegister("CorrelationUpdate", "1.0.0", CorrelationUpdate{}, "")
+	FillType             = types.MustRegister("Fill", "1.0.0", Fill{}, "")
+	PositionUpdateType   = types.MustRegister("PositionUpdate", "1.0.0", PositionUpdate{}, "")
+)
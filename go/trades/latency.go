@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Metadata keys that the producer stamps onto each event so that consumers can work out
+// how stale a trade is by the time it is processed.
+const (
+	ReceivedAt  = "received_at"
+	PublishedAt = "published_at"
+)
+
+// StampReceived and StampPublished record the producer timestamps in the event metadata
+func StampReceived(e *ensign.Event, ts time.Time) {
+	if e.Metadata == nil {
+		e.Metadata = make(ensign.Metadata)
+	}
+	e.Metadata.Set(ReceivedAt, ts.UTC().Format(time.RFC3339Nano))
+}
+
+func StampPublished(e *ensign.Event, ts time.Time) {
+	if e.Metadata == nil {
+		e.Metadata = make(ensign.Metadata)
+	}
+	e.Metadata.Set(PublishedAt, ts.UTC().Format(time.RFC3339Nano))
+}
+
+// CopyMetadata returns a copy of the metadata of a consumed event for an event derived
+// from it, so that the latency stamps survive a stage without the publish span of the
+// derived event being written into the consumed event.
+func CopyMetadata(source *ensign.Event) ensign.Metadata {
+	meta := make(ensign.Metadata, len(source.Metadata))
+	for key, val := range source.Metadata {
+		meta.Set(key, val)
+	}
+	return meta
+}
+
+// The hops that latency is measured across, from the exchange to the consumer
+const (
+	ExchangeToReceive = "exchange_to_receive"
+	ReceiveToPublish  = "receive_to_publish"
+	PublishToConsume  = "publish_to_consume"
+	EndToEnd          = "end_to_end"
+)
+
+var hops = []string{ExchangeToReceive, ReceiveToPublish, PublishToConsume, EndToEnd}
+
+// Histogram buckets latencies in milliseconds using exponentially growing bounds so
+// that both sub-millisecond network hops and multi-second delays can be summarized
+// with a small, fixed amount of memory.
+type Histogram struct {
+	Counts []uint64
+	Total  uint64
+	Max    float64
+}
+
+// Bucket i holds observations <= bucketBounds[i] milliseconds; the last bucket is +Inf
+var bucketBounds = func() []float64 {
+	bounds := make([]float64, 0, 40)
+	for b := 0.25; b < 600000; b *= 1.5 {
+		bounds = append(bounds, b)
+	}
+	return append(bounds, math.Inf(1))
+}()
+
+func NewHistogram() *Histogram {
+	return &Histogram{Counts: make([]uint64, len(bucketBounds))}
+}
+
+// Observe adds a latency in milliseconds; negative values caused by clock skew are
+// counted in the lowest bucket.
+func (h *Histogram) Observe(ms float64) {
+	i := sort.SearchFloat64s(bucketBounds, ms)
+	h.Counts[i]++
+	h.Total++
+	if ms > h.Max {
+		h.Max = ms
+	}
+}
+
+// Quantile returns the upper bound of the bucket that contains the q quantile
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.Total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.Total)))
+	var seen uint64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank {
+			if math.IsInf(bucketBounds[i], 1) {
+				return h.Max
+			}
+			return math.Min(bucketBounds[i], h.Max)
+		}
+	}
+	return h.Max
+}
+
+// LatencyReport is the periodic summary of latencies for a single symbol and hop, it
+// is printed by the consumer and optionally published as a "LatencyReport" event.
+type LatencyReport struct {
+	Symbol string    `json:"symbol"`
+	Hop    string    `json:"hop"`
+	Count  uint64    `json:"count"`
+	P50    float64   `json:"p50_ms"`
+	P95    float64   `json:"p95_ms"`
+	P99    float64   `json:"p99_ms"`
+	Max    float64   `json:"max_ms"`
+	Window time.Time `json:"window_end"`
+}
+
+// LatencyTracker keeps a histogram per symbol and hop; it is safe for concurrent use
+// since several Announce goroutines may be reading from the same subscription.
+type LatencyTracker struct {
+	sync.Mutex
+	histograms map[string]map[string]*Histogram
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{histograms: make(map[string]map[string]*Histogram)}
+}
+
+// Observe records the latencies of every trade in the response using the exchange
+// timestamp on the trade and the producer timestamps in the event metadata.
+func (t *LatencyTracker) Observe(e *ensign.Event, msg *Response, consumed time.Time) {
+	received, rerr := time.Parse(time.RFC3339Nano, e.Metadata.Get(ReceivedAt))
+	published, perr := time.Parse(time.RFC3339Nano, e.Metadata.Get(PublishedAt))
+
+	t.Lock()
+	defer t.Unlock()
+	for _, trade := range msg.Data {
+		exchange := time.UnixMilli(int64(trade.Timestamp))
+		if rerr == nil {
+			t.observe(trade.Symbol, ExchangeToReceive, received.Sub(exchange))
+		}
+		if rerr == nil && perr == nil {
+			t.observe(trade.Symbol, ReceiveToPublish, published.Sub(received))
+		}
+		if perr == nil {
+			t.observe(trade.Symbol, PublishToConsume, consumed.Sub(published))
+		}
+		t.observe(trade.Symbol, EndToEnd, consumed.Sub(exchange))
+	}
+}
+
+func (t *LatencyTracker) observe(symbol, hop string, latency time.Duration) {
+	if _, ok := t.histograms[symbol]; !ok {
+		t.histograms[symbol] = make(map[string]*Histogram)
+	}
+	if _, ok := t.histograms[symbol][hop]; !ok {
+		t.histograms[symbol][hop] = NewHistogram()
+	}
+	t.histograms[symbol][hop].Observe(float64(latency) / float64(time.Millisecond))
+}
+
+// Reports summarizes the histograms and resets them so each report covers one window
+func (t *LatencyTracker) Reports() []*LatencyReport {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	symbols := make([]string, 0, len(t.histograms))
+	for symbol := range t.histograms {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	reports := make([]*LatencyReport, 0, len(symbols)*len(hops))
+	for _, symbol := range symbols {
+		for _, hop := range hops {
+			h, ok := t.histograms[symbol][hop]
+			if !ok {
+				continue
+			}
+			reports = append(reports, &LatencyReport{
+				Symbol: symbol,
+				Hop:    hop,
+				Count:  h.Total,
+				P50:    h.Quantile(0.50),
+				P95:    h.Quantile(0.95),
+				P99:    h.Quantile(0.99),
+				Max:    h.Max,
+				Window: now,
+			})
+		}
+	}
+
+	t.histograms = make(map[string]map[string]*Histogram)
+	return reports
+}
+
+// Summarize logs a latency summary every interval until the context is canceled and, if
+// a topic is specified, publishes each report as a "LatencyReport" event to that topic.
+func (t *LatencyTracker) Summarize(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, topic string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reports := t.Reports()
+		slog.Info("latency summary", "reports", len(reports))
+		for _, r := range reports {
+			slog.Info("latency", "symbol", r.Symbol, "hop", r.Hop, "count", r.Count, "p50_ms", r.P50, "p95_ms", r.P95, "p99_ms", r.P99, "max_ms", r.Max)
+
+			if topic == "" {
+				continue
+			}
+
+			e := &ensign.Event{}
+
+			var err error
+			if err = LatencyReportType.Encode(codec.JSON, e, r); err != nil {
+				slog.Error("could not marshal latency report", "error", err)
+				continue
+			}
+
+			if err = publish(ctx, client, stats, topic, e); err != nil {
+				slog.Error("could not publish latency report", logger.KeyTopic, topic, "error", err)
+			}
+		}
+	}
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
 	ensign "github.com/rotationalio/go-ensign"
-	api "github.com/rotationalio/go-ensign/api/v1beta1"
-	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
 )
 
 // This is the nickname of the topic, it will get mapped to an ID that actually gets used by Ensign
@@ -20,8 +27,17 @@ const Trades = "trades"
 type Data struct {
 	Symbol     string   `json:"s"`
 	Price      float64  `json:"p"`
+	Volume     float64  `json:"v"`
 	Timestamp  uint64   `json:"t"`
 	Conditions []string `json:"c" omitempty:"true"`
+
+	// Added by the ConditionFilter when tagging is enabled, not part of the Finnhub API
+	Tags  []string `json:"tags,omitempty"`
+	Flags string   `json:"flags,omitempty"`
+
+	// Added by the producer from the market calendar, not part of the Finnhub API
+	Session    string `json:"session,omitempty"`
+	TradingDay string `json:"trading_day,omitempty"`
 }
 
 // This represents the entire websocket response that comes back from a single call to the Finnhub Server
@@ -33,98 +49,207 @@ type Response struct {
 
 // Announce is a helper function that takes as input a event chan that gets created by calling sub.Subscribe()
 // and ranges over any events that it receives on the chan, unmarshals them, and prints them out
-func Announce(events <-chan *ensign.Event) {
+// Trades are passed through the condition filter first so odd-lot and late prints can be dropped or tagged
+// and then the latency tracker records how long it took each trade to get here from the exchange
+// Events that can't be unmarshaled are nacked and moved to the dead letter topic instead of stopping the stream
+// Every log line about a tick carries the topic, event ID, event type and trace ID and errors are logged by the mux
+func Announce(events <-chan *ensign.Event, filter *ConditionFilter, latency *LatencyTracker, dlq *deadletter.Consumer, stats *metrics.Metrics) {
+	announce := TradesMux(dlq, stats, func(ctx context.Context, tick *ensign.Event, trades *Response) error {
+		log := logger.FromContext(ctx)
+		consumed := time.Now()
+		if dropped := filter.Apply(trades); dropped > 0 {
+			log.Info("dropped trades by condition", "dropped", dropped)
+		}
+		latency.Observe(tick, trades, consumed)
+		log.Info("announcing trades", "type", trades.Type, "data", trades.Data)
+		return nil
+	})
+
 	for tick := range events {
-		trades := &Response{}
-		if err := json.Unmarshal(tick.Data, &trades); err != nil {
-			panic("unable to unmarshal event: " + err.Error())
+		if err := announce.Dispatch(context.Background(), tick); err != nil {
+			tick.Nack(mux.NackCode(err))
+			continue
 		}
-		fmt.Println(trades)
+		tick.Ack()
 	}
 }
 
-func main() {
+// TradesMux routes the events on the Trades topic to the handler with the middleware
+// that every stage consuming the topic uses: each event is logged, traced and counted,
+// failed events are retried and then dead lettered, panics are recovered, and events
+// that can't be decoded are nacked with the code chosen by mux.DecodeFailed.
+func TradesMux(dlq *deadletter.Consumer, stats *metrics.Metrics, handler func(ctx context.Context, event *ensign.Event, trades *Response) error) *mux.Mux {
+	events := mux.New()
+	events.Use(mux.Logging(nil, Trades), tracing.Middleware(Trades), stats.Middleware(Trades), dlq.Middleware, mux.Recovery())
+	events.HandleType(TradesType, mux.Decoded(TradesType, handler))
+	return events
+}
 
-	// Create Ensign Client
-	client, err := ensign.New() // if your credentials are already in your bash profile, you don't have to pass anything into New()
-	// client, err := ensign.New(ensign.WithCredentials("YOUR CLIENT ID HERE!", "YOUR CLIENT SECRET HERE!"))
-	if err != nil {
-		panic(fmt.Errorf("could not create client: %s", err))
+// Commands are the additional stages that can be run on the trades stream by passing the
+// name of the stage as the first argument, e.g. go run . validate
+var commands = map[string]func(args []string){
+	"validate":  Validate,
+	"strategy":  RunStrategy,
+	"serve":     Serve,
+	"sink":      Sink,
+	"correlate": Correlate,
+	"enrich":    Enrich,
+}
+
+func main() {
+	// Run a stage if one was specified, otherwise stream trades from Finnhub to Ensign
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
 	}
+	Stream(os.Args[1:])
+}
 
-	// Check to see if topic exists, if it does then the variable exists will be True
-	exists, err := client.TopicExists(context.Background(), Trades)
+// EnsureTopic checks to see if the topic exists and creates it if it does not
+func EnsureTopic(client *ensign.Client, topic string) {
+	exists, err := client.TopicExists(context.Background(), topic)
 	if err != nil {
-		panic(fmt.Errorf("unable to check topic existence: %s", err))
+		logger.Fatal("unable to check topic existence", err, logger.KeyTopic, topic)
 	}
 
-	// If the topic does not exist, create it using the CreateTopic method
 	if !exists {
-		if _, err = client.CreateTopic(context.Background(), Trades); err != nil {
-			panic(fmt.Errorf("unable to create topic: %s", err))
+		if _, err = client.CreateTopic(context.Background(), topic); err != nil {
+			logger.Fatal("unable to create topic", err, logger.KeyTopic, topic)
 		}
 	}
+}
+
+// Stream trades from the Finnhub websocket, publish them to the Trades topic and consume
+// them again to print them out along with latency summaries.
+func Stream(args []string) {
+	fs := flag.NewFlagSet("trades", flag.ExitOnError)
+
+	// Condition filtering options for the consumer, e.g. -drop=odd-lot,out-of-sequence -tag
+	drop := fs.String("drop", "", "comma separated condition flags to drop (odd-lot, out-of-sequence, excluded-from-last, excluded-from-high-low, extended-hours)")
+	tag := fs.Bool("tag", false, "tag each trade with the meaning of its condition codes")
+
+	// Latency reporting options, e.g. -latency-interval=1m -latency-topic=trades-latency
+	latencyInterval := fs.Duration("latency-interval", 30*time.Second, "how often to print exchange to consumer latency summaries")
+	latencyTopic := fs.String("latency-topic", "", "if set, publish LatencyReport events to this topic")
+
+	// Trade source options, e.g. -source=file -path=trades.csv -speed=10 or -source=synthetic
+	// The complete list of symbols is long! This is a short list, but no guarantee that all will be updated for every tick
+	sourceKind := fs.String("source", "finnhub", "where to get trades from: finnhub, file or synthetic")
+	path := fs.String("path", "", "path to a csv or jsonl file of trades for the file source")
+	speed := fs.Float64("speed", 0, "replay speed multiple for the file source, 0 replays as fast as possible")
+	symbols := fs.String("symbols", "AAPL,AMZN,PCG,SNAP", "comma separated symbols for the finnhub and synthetic sources")
+	calendarPath := fs.String("calendar", "", "path to an updated holiday calendar, by default the embedded calendar is used")
+	format := fs.String("codec", "json", "encoding of the published trades: json, msgpack, gob or cbor")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)    // -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades")()
+
+	enc, err := codec.Named(*format)
+	if err != nil {
+		logger.Fatal("could not use codec", err)
+	}
+
+	filter := &ConditionFilter{Tag: *tag}
+	if filter.Drop, err = ParseConditionFlags(*drop); err != nil {
+		logger.Fatal("could not parse condition flags", err)
+	}
 
-	key := os.Getenv("FINNHUB_KEY")
-	if key == "" {
-		panic("Finnhub key is required: get one at https://finnhub.io/")
+	// Load the market calendar to tag each trade with its session and trading day
+	calendar, err := LoadCalendar(*calendarPath)
+	if err != nil {
+		logger.Fatal("could not load market calendar", err)
 	}
 
-	// Get trades from Finnhub - FYI this Dialer dials the "Trades" endpoint
-	// see https://finnhub.io/docs/api/websocket-trades for more details
-	finnhub_url := fmt.Sprint("wss://ws.finnhub.io?token=", key)
-	w, _, err := websocket.DefaultDialer.Dial(finnhub_url, nil)
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create client", err)
+	}
+
+	// Check to see if topic exists and create it if it does not
+	EnsureTopic(client, Trades)
+
+	// Create the latency report topic if we're publishing latency reports
+	if *latencyTopic != "" {
+		EnsureTopic(client, *latencyTopic)
 	}
-	defer w.Close()
 
-	// The complete list of options is long! This is a short list, but no guarantee that all will be updated for every tick
-	symbols := []string{"AAPL", "AMZN", "PCG", "SNAP"}
-	for _, s := range symbols {
-		msg, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "symbol": s})
-		w.WriteMessage(websocket.TextMessage, msg)
+	// Trades that can't be announced are moved to the trades-dlq topic
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+
+	// Periodically summarize the latencies observed by the consumer
+	latency := NewLatencyTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go latency.Summarize(ctx, client, stats, *latencyTopic, *latencyInterval)
+
+	// Get trades from the source, by default this is the Finnhub websocket
+	source, err := NewTradeSource(*sourceKind, *path, strings.Split(*symbols, ","), *speed)
+	if err != nil {
+		logger.Fatal("could not create trade source", err, "source", *sourceKind)
 	}
+	defer source.Close()
 
 	// Create a subscriber  - the same subscriber should be consuming each event that comes down the pipe
 	sub, err := client.Subscribe(Trades)
 	if err != nil {
-		fmt.Printf("could not create subscriber: %s", err)
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
 	}
 
-	// Loop over each response that is returned by the Finnhub websocket, publish it to the topicID, have the subscriber consume to the events channel
+	// A single goroutine consumes the events channel to ensure that the subscriber is getting all the ticks!
+	go Announce(sub.C, filter, latency, dlq, stats)
+
+	// Loop over each response that is returned by the trade source, publish it to the topicID, have the subscriber consume to the events channel
 	for {
-		// The Response struct is how we will boost the standard json marshalling library to know how to unpack and repackage Finnhub ticks
-		msg := &Response{}
-		err := w.ReadJSON(&msg)
+		msg, err := source.Next()
 		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Message from the websocket server is ", msg)
-
-		e := &ensign.Event{
-			Mimetype: mimetype.ApplicationJSON,
-			Type: &api.Type{
-				Name:         "Generic",
-				MajorVersion: 1,
-				MinorVersion: 0,
-				PatchVersion: 0,
-			},
-		}
-
-		if e.Data, err = json.Marshal(msg); err != nil {
-			panic("could not marshal data to JSON: " + err.Error())
+			if errors.Is(err, io.EOF) {
+				log.Info("all trades from the source have been published")
+				break
+			}
+			logger.Fatal("could not read from the trade source", err, "source", *sourceKind)
 		}
+		log.Debug("message from the trade source", "type", msg.Type, "trades", len(msg.Data))
+		calendar.Tag(msg)
 
 		// Publish the newly received tick event to the Topic
-		fmt.Printf("Publishing to topic: %s\n", Trades)
-		time.Sleep(1 * time.Second)
-		if err = client.Publish(Trades, e); err != nil {
-			panic("could not publish event: " + err.Error())
+		if err = PublishTrades(context.Background(), client, stats, Trades, enc, msg, time.Now()); err != nil {
+			logger.Fatal("could not publish event", err, logger.KeyTopic, Trades)
 		}
+	}
+
+	// Give the subscriber a moment to finish consuming the trades from a finite source
+	time.Sleep(5 * time.Second)
+}
 
-		// Goroutine to check the events channel to ensure that subscriber is getting all the ticks!
-		time.Sleep(1 * time.Second)
-		go Announce(sub.C)
+// PublishTrades is the publish path that every trade source goes through: the trades are
+// encoded with the codec and stamped with the Trades event type, the time they were received and published and
+// sent to the topic, counting the published events in the metrics and starting a trace for each event.
+func PublishTrades(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, topic string, enc codec.Codec, msg *Response, received time.Time) (err error) {
+	e := &ensign.Event{}
+	StampReceived(e, received)
+
+	if err = TradesType.Encode(enc, e, msg); err != nil {
+		return err
 	}
+
+	slog.Debug("publishing to topic", logger.KeyTopic, topic, logger.KeyEventType, TradesType.String())
+	StampPublished(e, time.Now())
+	return publish(ctx, client, stats, topic, e)
+}
+
+// publish sends the event to the topic in a span that continues the trace in the context
+// and counts it in the metrics
+func publish(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, topic string, e *ensign.Event) (err error) {
+	_, span := tracing.StartPublish(ctx, topic, e)
+	err = stats.Publish(client, topic, e)
+	tracing.End(span, err)
+	return err
 }
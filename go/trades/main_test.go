@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
+)
+
+// fakeClient records the dead lettered events instead of sending them to Ensign
+type fakeClient struct {
+	sync.Mutex
+	published map[string][]*ensign.Event
+}
+
+func (c *fakeClient) Publish(topic string, events ...*ensign.Event) error {
+	c.Lock()
+	defer c.Unlock()
+	c.published[topic] = append(c.published[topic], events...)
+	return nil
+}
+
+func (c *fakeClient) Subscribe(topics ...string) (*ensign.Subscription, error) {
+	return nil, errors.New("the fake client cannot subscribe")
+}
+
+func (c *fakeClient) TopicExists(ctx context.Context, topic string) (bool, error) {
+	return true, nil
+}
+
+func (c *fakeClient) CreateTopic(ctx context.Context, topic string) (string, error) {
+	return topic, nil
+}
+
+func TestTradesMux(t *testing.T) {
+	client := &fakeClient{published: make(map[string][]*ensign.Event)}
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades, Policy: deadletter.Policy{Attempts: 2, Backoff: time.Millisecond}}
+
+	var handled []*Response
+	events := TradesMux(dlq, metrics.New(), func(ctx context.Context, event *ensign.Event, trades *Response) error {
+		if trades.Type == "panic" {
+			panic("bad trades")
+		}
+		handled = append(handled, trades)
+		return nil
+	})
+
+	event := func(msg *Response) *ensign.Event {
+		e := &ensign.Event{Metadata: make(ensign.Metadata)}
+		if err := TradesType.Encode(codec.JSON, e, msg); err != nil {
+			t.Fatalf("could not encode trades: %s", err)
+		}
+		return e
+	}
+
+	// Trades are decoded and passed to the handler
+	trades := &Response{Type: "trade", Data: []Data{{Symbol: "AAPL", Price: 189.5, Volume: 10, Timestamp: 1000}}}
+	if err := events.Dispatch(context.Background(), event(trades)); err != nil {
+		t.Fatalf("expected the trades to be handled, got %s", err)
+	}
+	if len(handled) != 1 || handled[0].Data[0].Symbol != "AAPL" {
+		t.Fatalf("expected the handler to get the trades, got %+v", handled)
+	}
+
+	// Events that can't be decoded are nacked with the code from mux.DecodeFailed and
+	// dead lettered without calling the handler
+	unknownMime := event(trades)
+	unknownMime.Mimetype = mimetype.TextCSV
+
+	incompatible := event(trades)
+	incompatible.Type.MajorVersion = 2
+
+	testCases := []struct {
+		name  string
+		event *ensign.Event
+		code  api.Nack_Code
+	}{
+		{"unknown mimetype", unknownMime, api.Nack_UNHANDLED_MIMETYPE},
+		{"incompatible version", incompatible, api.Nack_UNKNOWN_TYPE},
+		{"handler panic", event(&Response{Type: "panic"}), api.Nack_UNPROCESSED},
+	}
+
+	for _, tc := range testCases {
+		err := events.Dispatch(context.Background(), tc.event)
+		if err == nil {
+			t.Errorf("%s: expected the event to be nacked", tc.name)
+			continue
+		}
+		if code := mux.NackCode(err); code != tc.code {
+			t.Errorf("%s: expected nack code %s, got %s", tc.name, tc.code, code)
+		}
+	}
+
+	if len(handled) != 1 {
+		t.Errorf("expected the handler to only be called for the trades, got %d calls", len(handled))
+	}
+	if dead := client.published[deadletter.Topic(Trades)]; len(dead) != len(testCases) {
+		t.Errorf("expected %d events to be dead lettered, got %d", len(testCases), len(dead))
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/mux"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+)
+
+// Topics that the validation stage publishes to
+const (
+	TradesClean      = "trades-clean"
+	TradesQuarantine = "trades-quarantine"
+)
+
+// Reasons that a trade can be quarantined by the validator
+const (
+	ReasonOutOfOrder       = "out-of-order timestamp"
+	ReasonDuplicate        = "duplicate trade"
+	ReasonNonPositivePrice = "non-positive price"
+	ReasonPriceSpike       = "price spike"
+)
+
+// QuarantinedTrade is published to the quarantine topic for every trade that fails validation
+type QuarantinedTrade struct {
+	Trade  Data   `json:"trade"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Validator keeps a small amount of recent state for each symbol so that it can catch
+// out-of-order timestamps, duplicate trades, non-positive prices and price spikes that
+// are more than MaxStdDevs standard deviations away from the recent mean. A run of
+// Rebaseline consecutive spikes is treated as a real shift in the price level rather
+// than bad prints, so the spiking prices become the new baseline for the symbol.
+type Validator struct {
+	Window     int     // the number of recent prices and trades to remember per symbol
+	MaxStdDevs float64 // how far from the recent mean a price can be before it is a spike
+	Rebaseline int     // the number of consecutive spikes that reset the recent prices
+	symbols    map[string]*symbolState
+}
+
+type symbolState struct {
+	lastTimestamp uint64
+	prices        []float64
+	spikes        []float64 // consecutive spiking prices since the last clean trade
+	seen          map[string]struct{}
+	order         []string
+}
+
+func NewValidator(window int, maxStdDevs float64, rebaseline int) *Validator {
+	return &Validator{
+		Window:     window,
+		MaxStdDevs: maxStdDevs,
+		Rebaseline: rebaseline,
+		symbols:    make(map[string]*symbolState),
+	}
+}
+
+// Check returns an empty reason if the trade is clean, otherwise the reason and some
+// detail about why the trade failed validation. Only clean trades update the state,
+// except that spiking prices are remembered until there are enough to re-baseline.
+func (v *Validator) Check(trade Data) (reason, detail string) {
+	state, ok := v.symbols[trade.Symbol]
+	if !ok {
+		state = &symbolState{seen: make(map[string]struct{})}
+		v.symbols[trade.Symbol] = state
+	}
+
+	if trade.Price <= 0 {
+		return ReasonNonPositivePrice, fmt.Sprintf("price %f", trade.Price)
+	}
+
+	key := fmt.Sprintf("%d|%f|%f|%v", trade.Timestamp, trade.Price, trade.Volume, trade.Conditions)
+	if _, ok := state.seen[key]; ok {
+		return ReasonDuplicate, fmt.Sprintf("already saw trade at %d", trade.Timestamp)
+	}
+
+	if trade.Timestamp < state.lastTimestamp {
+		return ReasonOutOfOrder, fmt.Sprintf("timestamp %d is before %d", trade.Timestamp, state.lastTimestamp)
+	}
+
+	// Only check for spikes once there are enough prices for a meaningful deviation
+	if len(state.prices) >= v.Window/2 && len(state.prices) > 1 {
+		mean, stddev := meanStdDev(state.prices)
+		if stddev > 0 {
+			if z := math.Abs(trade.Price-mean) / stddev; z > v.MaxStdDevs {
+				state.spikes = append(state.spikes, trade.Price)
+				if v.Rebaseline <= 0 || len(state.spikes) < v.Rebaseline {
+					return ReasonPriceSpike, fmt.Sprintf("price %f is %.1f standard deviations from the mean %f", trade.Price, z, mean)
+				}
+
+				// The price has moved to a new level, start over from the spiking prices
+				// and let this trade through as the first clean trade at the new level
+				state.prices = state.spikes[:len(state.spikes)-1]
+			}
+		}
+	}
+
+	// The trade is clean so remember it
+	state.spikes = nil
+	state.lastTimestamp = trade.Timestamp
+	state.prices = append(state.prices, trade.Price)
+	if len(state.prices) > v.Window {
+		state.prices = state.prices[1:]
+	}
+
+	state.seen[key] = struct{}{}
+	state.order = append(state.order, key)
+	if len(state.order) > v.Window {
+		delete(state.seen, state.order[0])
+		state.order = state.order[1:]
+	}
+	return "", ""
+}
+
+func meanStdDev(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	for _, v := range values {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(values)))
+}
+
+// Validate consumes the Trades topic, republishing clean trades to the trades-clean
+// topic and sending any trades that fail validation to the trades-quarantine topic with
+// the reason attached so that downstream bars and indicators aren't polluted.
+func Validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	window := fs.Int("window", 50, "number of recent trades per symbol used for duplicate and spike detection")
+	maxStdDevs := fs.Float64("max-stddevs", 6, "quarantine prices more than this many standard deviations from the recent mean")
+	rebaseline := fs.Int("rebaseline", 5, "treat this many consecutive price spikes as a new price level, 0 never re-baselines")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades-validate")()
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+
+	for _, topic := range []string{Trades, TradesClean, TradesQuarantine} {
+		EnsureTopic(client, topic)
+	}
+
+	sub, err := client.Subscribe(Trades)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+	}
+	defer sub.Close()
+
+	// The validator remembers every trade it has checked so a retried event would have
+	// its trades quarantined as duplicates, failed events are dead lettered right away
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades, Retryable: func(error) bool { return false }}
+	validator := NewValidator(*window, *maxStdDevs, *rebaseline)
+	events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, msg *Response) (err error) {
+		log := logger.FromContext(ctx)
+
+		// A trade that can't be quarantined would be lost if the event were acked, so the
+		// event is nacked to be redelivered instead
+		var quarantineErr error
+		clean := &Response{Type: msg.Type}
+		for _, trade := range msg.Data {
+			reason, detail := validator.Check(trade)
+			if reason == "" {
+				clean.Data = append(clean.Data, trade)
+				continue
+			}
+
+			log.Warn("quarantining trade", "symbol", trade.Symbol, "timestamp", trade.Timestamp, "reason", reason, "detail", detail)
+			if err = Quarantine(ctx, client, stats, event, trade, reason, detail); err != nil {
+				log.Error("could not publish quarantined trade", "symbol", trade.Symbol, "error", err)
+				quarantineErr = err
+			}
+		}
+
+		if quarantineErr != nil {
+			return mux.Nack(api.Nack_UNPROCESSED, fmt.Errorf("could not quarantine trades: %w", quarantineErr))
+		}
+
+		if len(clean.Data) > 0 {
+			// Keep the original metadata so that latency stamps survive the stage and
+			// encode the clean trades in the same format that they were published in
+			e := &ensign.Event{Metadata: CopyMetadata(event)}
+
+			enc, _ := codec.Default.Lookup(event.Mimetype)
+			if err = TradesType.Encode(enc, e, clean); err != nil {
+				return fmt.Errorf("could not encode clean trades: %w", err)
+			}
+
+			if err = publish(ctx, client, stats, TradesClean, e); err != nil {
+				return fmt.Errorf("could not publish clean trades: %w", err)
+			}
+		}
+		return nil
+	})
+
+	if err = events.Serve(context.Background(), sub); err != nil {
+		logger.Fatal("subscriber stopped", err, logger.KeyTopic, Trades)
+	}
+}
+
+// Quarantine publishes a trade that failed validation with the reason in the metadata,
+// in the trace of the event that the trade came from
+func Quarantine(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, source *ensign.Event, trade Data, reason, detail string) (err error) {
+	e := &ensign.Event{Metadata: CopyMetadata(source)}
+	e.Metadata.Set("reason", reason)
+
+	if err = QuarantinedTradeType.Encode(codec.JSON, e, &QuarantinedTrade{Trade: trade, Reason: reason, Detail: detail}); err != nil {
+		return err
+	}
+	return publish(ctx, client, stats, TradesQuarantine, e)
+}
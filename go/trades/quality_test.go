@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+// tradesAround returns n trades for the symbol alternating around the price so that the
+// recent prices have a small, non-zero standard deviation
+func tradesAround(symbol string, price float64, n int, start uint64) []Data {
+	trades := make([]Data, 0, n)
+	for i := 0; i < n; i++ {
+		offset := 0.1
+		if i%2 == 1 {
+			offset = -0.1
+		}
+		trades = append(trades, Data{Symbol: symbol, Price: price + offset, Volume: 1, Timestamp: start + uint64(i)})
+	}
+	return trades
+}
+
+func TestValidatorChecks(t *testing.T) {
+	v := NewValidator(50, 6, 5)
+	for _, trade := range tradesAround("AAPL", 100, 30, 1000) {
+		if reason, detail := v.Check(trade); reason != "" {
+			t.Fatalf("expected warm up trade to be clean, got %s: %s", reason, detail)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		trade  Data
+		reason string
+	}{
+		{"clean", Data{Symbol: "AAPL", Price: 100, Volume: 2, Timestamp: 2000}, ""},
+		{"duplicate", Data{Symbol: "AAPL", Price: 100, Volume: 2, Timestamp: 2000}, ReasonDuplicate},
+		{"out of order", Data{Symbol: "AAPL", Price: 100, Volume: 3, Timestamp: 1500}, ReasonOutOfOrder},
+		{"zero price", Data{Symbol: "AAPL", Price: 0, Volume: 1, Timestamp: 2001}, ReasonNonPositivePrice},
+		{"negative price", Data{Symbol: "AAPL", Price: -1, Volume: 1, Timestamp: 2002}, ReasonNonPositivePrice},
+		{"spike", Data{Symbol: "AAPL", Price: 150, Volume: 1, Timestamp: 2003}, ReasonPriceSpike},
+		{"clean after spike", Data{Symbol: "AAPL", Price: 100.1, Volume: 1, Timestamp: 2004}, ""},
+		{"other symbol", Data{Symbol: "AMZN", Price: 150, Volume: 1, Timestamp: 10}, ""},
+	}
+
+	for _, tc := range testCases {
+		if reason, detail := v.Check(tc.trade); reason != tc.reason {
+			t.Errorf("%s: expected reason %q got %q (%s)", tc.name, tc.reason, reason, detail)
+		}
+	}
+}
+
+func TestValidatorStepChange(t *testing.T) {
+	v := NewValidator(50, 6, 5)
+	for _, trade := range tradesAround("PCG", 100, 30, 1000) {
+		if reason, _ := v.Check(trade); reason != "" {
+			t.Fatalf("expected warm up trade to be clean, got %s", reason)
+		}
+	}
+
+	// The first spikes at the new level are quarantined until there are enough of them
+	// to re-baseline, then every later trade at the new level is clean
+	shifted := tradesAround("PCG", 150, 40, 2000)
+	for i, trade := range shifted {
+		reason, detail := v.Check(trade)
+		switch {
+		case i < 4 && reason != ReasonPriceSpike:
+			t.Errorf("trade %d: expected a price spike before re-baselining, got %q", i, reason)
+		case i >= 4 && reason != "":
+			t.Errorf("trade %d: expected a clean trade after re-baselining, got %q (%s)", i, reason, detail)
+		}
+	}
+
+	// Outliers that aren't consecutive don't re-baseline
+	for i, price := range []float64{300, 150, 300, 150.1, 300} {
+		reason, _ := v.Check(Data{Symbol: "PCG", Price: price, Volume: 7, Timestamp: uint64(3000 + i)})
+		if price == 300 && reason != ReasonPriceSpike {
+			t.Errorf("outlier %d: expected a price spike got %q", i, reason)
+		}
+	}
+}
+
+func TestValidatorNoRebaseline(t *testing.T) {
+	v := NewValidator(50, 6, 0)
+	for _, trade := range tradesAround("SNAP", 100, 30, 1000) {
+		v.Check(trade)
+	}
+
+	for i, trade := range tradesAround("SNAP", 150, 20, 2000) {
+		if reason, _ := v.Check(trade); reason != ReasonPriceSpike {
+			t.Fatalf("trade %d: expected every trade to spike without re-baselining, got %q", i, reason)
+		}
+	}
+}
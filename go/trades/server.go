@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// The bundled page that charts prices live from the SSE endpoint
+//
+//go:embed static/index.html
+var indexHTML []byte
+
+// Hub fans trades out from a single Ensign subscription to many browser clients. Each
+// client has a small buffer; clients that can't keep up are disconnected rather than
+// being allowed to block the broadcast to everyone else.
+type Hub struct {
+	sync.RWMutex
+	clients map[*Client]struct{}
+	buffer  int
+}
+
+// Client is a single browser connection that may only want trades for some symbols
+type Client struct {
+	symbols map[string]struct{}
+	send    chan Data
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewHub(buffer int) *Hub {
+	return &Hub{clients: make(map[*Client]struct{}), buffer: buffer}
+}
+
+// Register a new client with the symbols from the query string, e.g. ?symbols=AAPL,SNAP
+// (no symbols means the client receives all of the trades).
+func (h *Hub) Register(r *http.Request) *Client {
+	c := &Client{
+		symbols: make(map[string]struct{}),
+		send:    make(chan Data, h.buffer),
+		done:    make(chan struct{}),
+	}
+
+	for _, symbol := range strings.Split(r.URL.Query().Get("symbols"), ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			c.symbols[symbol] = struct{}{}
+		}
+	}
+
+	h.Lock()
+	h.clients[c] = struct{}{}
+	h.Unlock()
+	return c
+}
+
+// Unregister removes the client from the hub and signals that it is done
+func (h *Hub) Unregister(c *Client) {
+	h.Lock()
+	delete(h.clients, c)
+	h.Unlock()
+	c.once.Do(func() { close(c.done) })
+}
+
+// Broadcast sends the trade to every interested client without blocking
+func (h *Hub) Broadcast(trade Data) {
+	var slow []*Client
+	h.RLock()
+	for c := range h.clients {
+		if !c.Wants(trade.Symbol) {
+			continue
+		}
+
+		select {
+		case c.send <- trade:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.RUnlock()
+
+	for _, c := range slow {
+		slog.Warn("disconnecting slow client")
+		h.Unregister(c)
+	}
+}
+
+func (c *Client) Wants(symbol string) bool {
+	if len(c.symbols) == 0 {
+		return true
+	}
+	_, ok := c.symbols[symbol]
+	return ok
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ServeWS streams trades to the client over a websocket
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("could not upgrade websocket", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	c := h.Register(r)
+	defer h.Unregister(c)
+
+	// Read from the websocket in the background so that closes are noticed
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				h.Unregister(c)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case trade := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(trade); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeSSE streams trades to the client as Server-Sent Events
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	c := h.Register(r)
+	defer h.Unregister(c)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-r.Context().Done():
+			return
+		case trade := <-c.send:
+			data, err := json.Marshal(trade)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: trade\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Serve subscribes to the Trades topic and rebroadcasts the trades to browser clients
+// over WebSockets (/ws) and Server-Sent Events (/events), along with a minimal page at
+// / that charts prices live so that the feed can be watched without running Go.
+func Serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address for the http server to listen on")
+	buffer := fs.Int("buffer", 64, "number of trades buffered per client before it is disconnected")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades-serve")()
+
+	// Create Ensign Client
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	EnsureTopic(client, Trades)
+
+	sub, err := client.Subscribe(Trades)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+	}
+	defer sub.Close()
+
+	hub := NewHub(*buffer)
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+	events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, msg *Response) error {
+		for _, trade := range msg.Data {
+			hub.Broadcast(trade)
+		}
+		return nil
+	})
+
+	go func() {
+		if err := events.Serve(context.Background(), sub); err != nil {
+			logger.Fatal("subscriber stopped", err, logger.KeyTopic, Trades)
+		}
+	}()
+
+	routes := http.NewServeMux()
+	routes.HandleFunc("/ws", hub.ServeWS)
+	routes.HandleFunc("/events", hub.ServeSSE)
+	routes.Handle("/metrics", stats.Handler())
+	routes.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+
+	log.Info("serving live trades", "url", "http://localhost"+*addr)
+	if err = http.ListenAndServe(*addr, routes); err != nil {
+		logger.Fatal("http server stopped", err, "addr", *addr)
+	}
+}
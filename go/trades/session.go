@@ -0,0 +1,116 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	_ "time/tzdata"
+)
+
+// Session is the part of the US equities trading day that a trade happened in
+type Session string
+
+const (
+	PreMarket  Session = "pre-market"
+	Regular    Session = "regular"
+	AfterHours Session = "after-hours"
+	Closed     Session = "closed"
+)
+
+// Session boundaries in minutes after midnight Eastern; after-hours trading ends four
+// hours after the close, which is 17:00 on early close days.
+const (
+	preMarketOpen   = 4 * 60
+	regularOpen     = 9*60 + 30
+	regularClose    = 16 * 60
+	afterHoursAfter = 4 * 60
+)
+
+// The embedded holiday and early close calendar, pass -calendar to use an updated copy
+//
+//go:embed static/calendar.json
+var calendarJSON []byte
+
+// Calendar holds the exchange holidays and early closes keyed by date (YYYY-MM-DD).
+// Early closes are the time (HH:MM Eastern) that the regular session ends.
+type Calendar struct {
+	Holidays    map[string]string `json:"holidays"`
+	EarlyCloses map[string]string `json:"early_closes"`
+	location    *time.Location
+}
+
+// LoadCalendar loads the calendar from the path or the embedded calendar if empty
+func LoadCalendar(path string) (cal *Calendar, err error) {
+	data := calendarJSON
+	if path != "" {
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	cal = &Calendar{}
+	if err = json.Unmarshal(data, cal); err != nil {
+		return nil, fmt.Errorf("could not parse calendar: %w", err)
+	}
+
+	if cal.location, err = time.LoadLocation("America/New_York"); err != nil {
+		return nil, err
+	}
+	return cal, nil
+}
+
+// Session returns the session that the timestamp falls in and the trading day date
+func (c *Calendar) Session(ts time.Time) (session Session, tradingDay string) {
+	local := ts.In(c.location)
+	tradingDay = local.Format("2006-01-02")
+
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return Closed, tradingDay
+	}
+
+	if _, ok := c.Holidays[tradingDay]; ok {
+		return Closed, tradingDay
+	}
+
+	closes := regularClose
+	if early, ok := c.EarlyCloses[tradingDay]; ok {
+		if at, err := time.Parse("15:04", early); err == nil {
+			closes = at.Hour()*60 + at.Minute()
+		}
+	}
+
+	minutes := local.Hour()*60 + local.Minute()
+	switch {
+	case minutes < preMarketOpen:
+		return Closed, tradingDay
+	case minutes < regularOpen:
+		return PreMarket, tradingDay
+	case minutes < closes:
+		return Regular, tradingDay
+	case minutes < closes+afterHoursAfter:
+		return AfterHours, tradingDay
+	default:
+		return Closed, tradingDay
+	}
+}
+
+// Tag sets the session and trading day on every trade in the response
+func (c *Calendar) Tag(msg *Response) {
+	for i := range msg.Data {
+		session, day := c.Session(time.UnixMilli(int64(msg.Data[i].Timestamp)))
+		msg.Data[i].Session = string(session)
+		msg.Data[i].TradingDay = day
+	}
+}
+
+// IsRegular returns true if the trade happened during the regular session, using the
+// session tag if the producer added one.
+func (c *Calendar) IsRegular(trade Data) bool {
+	if trade.Session != "" {
+		return trade.Session == string(Regular)
+	}
+	session, _ := c.Session(time.UnixMilli(int64(trade.Timestamp)))
+	return session == Regular
+}
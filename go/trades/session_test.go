@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalendarSession(t *testing.T) {
+	calendar, err := LoadCalendar("")
+	if err != nil {
+		t.Fatalf("could not load the embedded calendar: %s", err)
+	}
+
+	// Times are in UTC so the Eastern offset changes across the DST transitions on
+	// 2024-03-10 (EST to EDT) and 2024-11-03 (EDT to EST)
+	testCases := []struct {
+		name       string
+		ts         string
+		session    Session
+		tradingDay string
+	}{
+		{"before pre-market EST", "2024-03-08T08:59:00Z", Closed, "2024-03-08"},
+		{"pre-market open EST", "2024-03-08T09:00:00Z", PreMarket, "2024-03-08"},
+		{"before the open EST", "2024-03-08T14:29:59Z", PreMarket, "2024-03-08"},
+		{"regular open EST", "2024-03-08T14:30:00Z", Regular, "2024-03-08"},
+		{"regular close EST", "2024-03-08T21:00:00Z", AfterHours, "2024-03-08"},
+		{"after-hours close EST", "2024-03-09T01:00:00Z", Closed, "2024-03-08"},
+		{"saturday", "2024-03-09T15:00:00Z", Closed, "2024-03-09"},
+		{"sunday of the DST change", "2024-03-10T15:00:00Z", Closed, "2024-03-10"},
+		{"before pre-market EDT", "2024-03-11T07:59:00Z", Closed, "2024-03-11"},
+		{"pre-market open EDT", "2024-03-11T08:00:00Z", PreMarket, "2024-03-11"},
+		{"before the open EDT", "2024-03-11T13:29:59Z", PreMarket, "2024-03-11"},
+		{"regular open EDT", "2024-03-11T13:30:00Z", Regular, "2024-03-11"},
+		{"before the close EDT", "2024-03-11T19:59:59Z", Regular, "2024-03-11"},
+		{"regular close EDT", "2024-03-11T20:00:00Z", AfterHours, "2024-03-11"},
+		{"after midnight UTC", "2024-03-11T23:59:00Z", AfterHours, "2024-03-11"},
+		{"after-hours close EDT", "2024-03-12T00:00:00Z", Closed, "2024-03-11"},
+		{"regular open after DST ends", "2024-11-04T14:30:00Z", Regular, "2024-11-04"},
+		{"an hour early after DST ends", "2024-11-04T13:30:00Z", PreMarket, "2024-11-04"},
+		{"regular close after DST ends", "2024-11-04T21:00:00Z", AfterHours, "2024-11-04"},
+		{"good friday", "2024-03-29T15:00:00Z", Closed, "2024-03-29"},
+		{"independence day", "2024-07-04T15:00:00Z", Closed, "2024-07-04"},
+		{"observed holiday", "2026-07-03T15:00:00Z", Closed, "2026-07-03"},
+		{"early close regular", "2024-07-03T16:59:00Z", Regular, "2024-07-03"},
+		{"early close", "2024-07-03T17:00:00Z", AfterHours, "2024-07-03"},
+		{"early close after-hours", "2024-07-03T20:59:00Z", AfterHours, "2024-07-03"},
+		{"early close after-hours close", "2024-07-03T21:00:00Z", Closed, "2024-07-03"},
+		{"christmas eve EST", "2024-12-24T18:00:00Z", AfterHours, "2024-12-24"},
+	}
+
+	for _, tc := range testCases {
+		ts, err := time.Parse(time.RFC3339, tc.ts)
+		if err != nil {
+			t.Fatalf("%s: could not parse %s: %s", tc.name, tc.ts, err)
+		}
+
+		session, day := calendar.Session(ts)
+		if session != tc.session || day != tc.tradingDay {
+			t.Errorf("%s: expected %s on %s got %s on %s", tc.name, tc.session, tc.tradingDay, session, day)
+		}
+	}
+}
+
+func TestCalendarTag(t *testing.T) {
+	calendar, err := LoadCalendar("")
+	if err != nil {
+		t.Fatalf("could not load the embedded calendar: %s", err)
+	}
+
+	open := time.Date(2024, 3, 11, 13, 30, 0, 0, time.UTC)
+	msg := &Response{Type: "trade", Data: []Data{
+		{Symbol: "AAPL", Timestamp: uint64(open.UnixMilli())},
+		{Symbol: "AAPL", Timestamp: uint64(open.Add(-time.Minute).UnixMilli())},
+	}}
+	calendar.Tag(msg)
+
+	if msg.Data[0].Session != string(Regular) || msg.Data[1].Session != string(PreMarket) || msg.Data[0].TradingDay != "2024-03-11" {
+		t.Errorf("unexpected tags %+v", msg.Data)
+	}
+
+	// The session tag from the producer is used if there is one
+	if !calendar.IsRegular(msg.Data[0]) || calendar.IsRegular(msg.Data[1]) {
+		t.Error("expected only the first trade to be regular")
+	}
+	if calendar.IsRegular(Data{Timestamp: msg.Data[0].Timestamp, Session: string(AfterHours)}) {
+		t.Error("expected the session tag to be used over the timestamp")
+	}
+	if !calendar.IsRegular(Data{Timestamp: msg.Data[0].Timestamp}) {
+		t.Error("expected an untagged trade to be classified by its timestamp")
+	}
+}
+
+func TestLoadCalendar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "calendar.json")
+	if err := os.WriteFile(path, []byte(`{"holidays": {"2024-03-11": "Test Day"}, "early_closes": {"2024-03-12": "12:00"}}`), 0644); err != nil {
+		t.Fatalf("could not write calendar: %s", err)
+	}
+
+	calendar, err := LoadCalendar(path)
+	if err != nil {
+		t.Fatalf("could not load calendar: %s", err)
+	}
+
+	if session, _ := calendar.Session(time.Date(2024, 3, 11, 15, 0, 0, 0, time.UTC)); session != Closed {
+		t.Errorf("expected the holiday from the file to be closed, got %s", session)
+	}
+	if session, _ := calendar.Session(time.Date(2024, 3, 12, 16, 0, 0, 0, time.UTC)); session != AfterHours {
+		t.Errorf("expected the early close from the file at noon, got %s", session)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(bad, []byte("not json"), 0644)
+	if _, err = LoadCalendar(bad); err == nil {
+		t.Error("expected an invalid calendar to fail")
+	}
+	if _, err = LoadCalendar(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected a missing calendar to fail")
+	}
+}
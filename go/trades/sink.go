@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
+	wmmetrics "github.com/ThreeDotsLabs/watermill/components/metrics"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/ThreeDotsLabs/watermill/message/router/plugin"
+	_ "github.com/lib/pq"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Sink persists trades into Postgres using the same watermill router pattern as the
+// weather consumer: a handler turns each trades event into a batch of trades and the
+// watermill-sql publisher inserts the batch using the tradesSchemaAdapter.
+func Sink(args []string) {
+	fs := flag.NewFlagSet("sink", flag.ExitOnError)
+	table := fs.String("table", "trades", "name of the postgres table to insert trades into")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+
+	// The router, bridge and publisher log to the same output through the adapter
+	wmLogger := logger.NewWatermill(logs.Setup())
+	stats := prom.Setup()
+	defer traces.Setup("trades-sink")()
+	router, err := message.NewRouter(message.RouterConfig{}, wmLogger)
+	if err != nil {
+		logger.Fatal("could not create router", err)
+	}
+
+	//SignalsHandler will gracefully shutdown Router when SIGTERM is received
+	router.AddPlugin(plugin.SignalsHandler)
+	//The Recoverer middleware handles panics from handlers
+	router.AddMiddleware(middleware.Recoverer)
+	//Count the messages handled by the router and time the handlers and the postgres publisher
+	wmmetrics.NewPrometheusMetricsBuilder(stats.Registry, metrics.Namespace, "watermill").AddPrometheusRouterMetrics(router)
+	//Continue the trace of each trades event in the handler and record a span for each insert
+	router.AddMiddleware(tracing.WatermillMiddleware)
+	router.AddPublisherDecorators(tracing.DecoratePublisher)
+
+	postgresDB := createPostgresConnection()
+	publisher, err := sql.NewPublisher(
+		postgresDB,
+		sql.PublisherConfig{
+			SchemaAdapter:        tradesSchemaAdapter{},
+			AutoInitializeSchema: true, //creates the trades table if it doesn't exist
+		},
+		wmLogger,
+	)
+	if err != nil {
+		logger.Fatal("could not create postgres publisher", err)
+	}
+
+	// The Ensign subscription is bridged into the router so that the sink uses the same
+	// go-ensign client as the rest of the trades example.
+	bridge := NewBridge()
+
+	router.AddHandler(
+		"trades_inserter",
+		Trades, //subscriber topic
+		bridge,
+		*table, //publisher topic (the table name)
+		publisher,
+		batchTrades, //handler function
+	)
+
+	go func() {
+		<-router.Running()
+		bridgeTrades(bridge, creds, stats)
+	}()
+
+	if err = router.Run(context.Background()); err != nil {
+		logger.Fatal("router stopped", err)
+	}
+}
+
+// bridgeTrades delivers each Ensign event to the router and acks the event once the
+// trades have been inserted, or nacks it if the handler or the insert failed. The trace
+// context of the event is copied into the message metadata so the insert joins the trace.
+func bridgeTrades(bridge *Bridge, creds *config.Flags, stats *metrics.Metrics) {
+	client, err := creds.Client() // credentials come from -credentials, -profile or $ENSIGN_CLIENT_ID/$ENSIGN_CLIENT_SECRET
+	if err != nil {
+		logger.Fatal("could not create client", err)
+	}
+	EnsureTopic(client, Trades)
+
+	sub, err := client.Subscribe(Trades)
+	if err != nil {
+		logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+	}
+
+	dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+	events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, trades *Response) (err error) {
+		// The router handlers expect JSON so re-encode the trades if they were published
+		// in another format
+		payload := event.Data
+		if event.Mimetype != codec.JSON.Mimetype() {
+			if payload, err = json.Marshal(trades); err != nil {
+				return fmt.Errorf("could not encode trades event: %w", err)
+			}
+		}
+
+		msg := message.NewMessage(watermill.NewUUID(), payload)
+		tracing.Inject(ctx, msg.Metadata)
+		if err = bridge.Deliver(msg); err != nil {
+			return fmt.Errorf("could not insert trades event: %w", err)
+		}
+		return nil
+	})
+
+	if err = events.Serve(context.Background(), sub); err != nil {
+		logger.Fatal("subscriber stopped", err, logger.KeyTopic, Trades)
+	}
+}
+
+// Errors returned by Bridge.Deliver
+var (
+	ErrNacked       = errors.New("the router nacked the message")
+	ErrBridgeClosed = errors.New("the bridge is closed")
+)
+
+// Bridge is the watermill subscriber that the sink router reads the Ensign events from.
+// Unlike a go channel, which redelivers a nacked message until it is acked so a trade
+// that can't be inserted would loop forever, the bridge returns the nack to the caller
+// of Deliver so that the Ensign event can be nacked instead.
+type Bridge struct {
+	mu       sync.RWMutex
+	closed   bool
+	messages chan *message.Message
+	closing  chan struct{}
+	once     sync.Once
+}
+
+func NewBridge() *Bridge {
+	return &Bridge{messages: make(chan *message.Message), closing: make(chan struct{})}
+}
+
+// Subscribe returns the messages passed to Deliver, the topic is ignored since the sink
+// has a single handler.
+func (b *Bridge) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	return b.messages, nil
+}
+
+// Deliver sends the message to the router and waits for the handler to ack or nack it
+func (b *Bridge) Deliver(msg *message.Message) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBridgeClosed
+	}
+
+	select {
+	case b.messages <- msg:
+	case <-b.closing:
+		return ErrBridgeClosed
+	}
+
+	select {
+	case <-msg.Acked():
+		return nil
+	case <-msg.Nacked():
+		return ErrNacked
+	case <-b.closing:
+		return ErrBridgeClosed
+	}
+}
+
+// Close stops delivering messages and closes the subscription so the router can stop
+func (b *Bridge) Close() error {
+	b.once.Do(func() {
+		close(b.closing)
+		b.mu.Lock()
+		b.closed = true
+		close(b.messages)
+		b.mu.Unlock()
+	})
+	return nil
+}
+
+// batchTrades turns a trades event into a single message that holds the batch of trades,
+// the router publishes produced messages one at a time so batching happens here rather
+// than by returning one message per trade.
+func batchTrades(msg *message.Message) ([]*message.Message, error) {
+	trades := &Response{}
+	if err := json.Unmarshal(msg.Payload, trades); err != nil {
+		return nil, err
+	}
+
+	// Finnhub sends pings and other non-trade messages that have nothing to insert
+	if len(trades.Data) == 0 {
+		return nil, nil
+	}
+
+	payload, err := json.Marshal(trades.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("inserting trades", "trades", len(trades.Data), "message_uuid", msg.UUID)
+	return []*message.Message{message.NewMessage(watermill.NewUUID(), payload)}, nil
+}
+
+func createPostgresConnection() *stdSQL.DB {
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	user := os.Getenv("POSTGRES_USER")
+	password := os.Getenv("POSTGRES_PASSWORD")
+	dbname := os.Getenv("POSTGRES_DB")
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+	db, err := stdSQL.Open("postgres", dsn)
+	if err != nil {
+		logger.Fatal("could not open postgres connection", err)
+	}
+
+	if err = db.Ping(); err != nil {
+		logger.Fatal("could not connect to postgres", err, "host", host, "port", port, "dbname", dbname)
+	}
+	return db
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
+	"github.com/ThreeDotsLabs/watermill/message"
+	_ "modernc.org/sqlite"
+)
+
+// The tests use an in-memory sqlite database as a stand-in for postgres; sqlite accepts
+// NUMERIC columns, the $n placeholders and ON CONFLICT DO NOTHING, so only the postgres
+// specific parts of the schema need to be rewritten.
+var sqliteSchema = strings.NewReplacer(
+	"BIGSERIAL NOT NULL PRIMARY KEY", "INTEGER PRIMARY KEY",
+	"TIMESTAMPTZ", "TIMESTAMP",
+	"TEXT[]", "TEXT",
+	"DEFAULT NOW()", "DEFAULT CURRENT_TIMESTAMP",
+)
+
+func openTradesDB(t *testing.T) *stdSQL.DB {
+	db, err := stdSQL.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open sqlite: %s", err)
+	}
+
+	// Each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	for _, query := range (tradesSchemaAdapter{}).SchemaInitializingQueries("trades") {
+		if _, err = db.Exec(sqliteSchema.Replace(query)); err != nil {
+			t.Fatalf("could not create the trades table: %s", err)
+		}
+	}
+	return db
+}
+
+func newTradesPublisher(t *testing.T, db *stdSQL.DB) message.Publisher {
+	publisher, err := sql.NewPublisher(db, sql.PublisherConfig{SchemaAdapter: tradesSchemaAdapter{}}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatalf("could not create the sql publisher: %s", err)
+	}
+	return publisher
+}
+
+func tradesMessage(t *testing.T, trades ...Data) *message.Message {
+	payload, err := json.Marshal(trades)
+	if err != nil {
+		t.Fatalf("could not marshal trades: %s", err)
+	}
+	return message.NewMessage(watermill.NewUUID(), payload)
+}
+
+func countTrades(t *testing.T, db *stdSQL.DB) (n int) {
+	if err := db.QueryRow("SELECT count(*) FROM trades").Scan(&n); err != nil {
+		t.Fatalf("could not count trades: %s", err)
+	}
+	return n
+}
+
+func TestTradesSchema(t *testing.T) {
+	queries := (tradesSchemaAdapter{}).SchemaInitializingQueries("trades")
+	if len(queries) != 2 {
+		t.Fatalf("expected the create table and index queries, got %d queries", len(queries))
+	}
+
+	for _, column := range []string{
+		"trade_key CHAR(64) NOT NULL UNIQUE",
+		"price NUMERIC(18,6) NOT NULL",
+		"volume NUMERIC(20,4) NOT NULL",
+		"traded_at TIMESTAMPTZ NOT NULL",
+		"conditions TEXT[]",
+	} {
+		if !strings.Contains(queries[0], column) {
+			t.Errorf("expected the trades table to have column %q", column)
+		}
+	}
+}
+
+func TestTradesInsert(t *testing.T) {
+	db := openTradesDB(t)
+	publisher := newTradesPublisher(t, db)
+
+	aapl := Data{Symbol: "AAPL", Price: 182.52, Volume: 100, Timestamp: 1685629800123, Conditions: []string{"1", "12"}}
+	amzn := Data{Symbol: "AMZN", Price: 122.5, Volume: 3.5, Timestamp: 1685629800456}
+	snap := Data{Symbol: "SNAP", Price: 10.01, Volume: 20, Timestamp: 1685629800789}
+
+	// Duplicates in the same batch are dropped before the insert
+	if err := publisher.Publish("trades", tradesMessage(t, aapl, amzn, aapl)); err != nil {
+		t.Fatalf("could not insert trades: %s", err)
+	}
+	if n := countTrades(t, db); n != 2 {
+		t.Fatalf("expected 2 trades after the first batch, got %d", n)
+	}
+
+	// Redelivered trades are ignored by ON CONFLICT (trade_key) DO NOTHING
+	if err := publisher.Publish("trades", tradesMessage(t, aapl, snap), tradesMessage(t, amzn)); err != nil {
+		t.Fatalf("could not insert trades: %s", err)
+	}
+	if n := countTrades(t, db); n != 3 {
+		t.Fatalf("expected 3 trades after the redelivered batch, got %d", n)
+	}
+
+	var (
+		key, symbol, conditions string
+		price, volume           float64
+		tradedAt                time.Time
+	)
+	row := db.QueryRow("SELECT trade_key, symbol, price, volume, traded_at, conditions FROM trades WHERE symbol = $1", "AAPL")
+	if err := row.Scan(&key, &symbol, &price, &volume, &tradedAt, &conditions); err != nil {
+		t.Fatalf("could not select the trade: %s", err)
+	}
+
+	if key != TradeKey(aapl) {
+		t.Errorf("expected trade key %s got %s", TradeKey(aapl), key)
+	}
+	if price != aapl.Price || volume != aapl.Volume {
+		t.Errorf("expected price %f and volume %f got %f and %f", aapl.Price, aapl.Volume, price, volume)
+	}
+	if expected := time.UnixMilli(int64(aapl.Timestamp)).UTC(); !tradedAt.Equal(expected) {
+		t.Errorf("expected traded_at %s got %s", expected, tradedAt)
+	}
+	if conditions != `{"1","12"}` {
+		t.Errorf("expected the conditions as a postgres array got %q", conditions)
+	}
+}
+
+func TestInsertNoTrades(t *testing.T) {
+	if _, _, err := (tradesSchemaAdapter{}).InsertQuery("trades", message.Messages{tradesMessage(t)}); err == nil {
+		t.Error("expected an error inserting an empty batch")
+	}
+}
+
+func TestBatchTrades(t *testing.T) {
+	// Finnhub pings have nothing to insert
+	ping, _ := json.Marshal(&Response{Type: "ping"})
+	if msgs, err := batchTrades(message.NewMessage(watermill.NewUUID(), ping)); err != nil || len(msgs) != 0 {
+		t.Errorf("expected no messages for a ping, got %d messages and error %v", len(msgs), err)
+	}
+
+	trades, _ := json.Marshal(&Response{Type: "trade", Data: []Data{{Symbol: "AAPL", Price: 1}, {Symbol: "PCG", Price: 2}}})
+	msgs, err := batchTrades(message.NewMessage(watermill.NewUUID(), trades))
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected a single batch, got %d messages and error %v", len(msgs), err)
+	}
+
+	var batch []Data
+	if err = json.Unmarshal(msgs[0].Payload, &batch); err != nil || len(batch) != 2 {
+		t.Errorf("expected a batch of 2 trades, got %d trades and error %v", len(batch), err)
+	}
+
+	if _, err = batchTrades(message.NewMessage(watermill.NewUUID(), []byte("not json"))); err == nil {
+		t.Error("expected an error for a payload that isn't trades")
+	}
+}
+
+func TestBridge(t *testing.T) {
+	db := openTradesDB(t)
+	bridge := NewBridge()
+
+	router, err := message.NewRouter(message.RouterConfig{CloseTimeout: time.Second}, watermill.NopLogger{})
+	if err != nil {
+		t.Fatalf("could not create router: %s", err)
+	}
+	router.AddHandler("trades_inserter", Trades, bridge, "trades", newTradesPublisher(t, db), batchTrades)
+
+	go router.Run(context.Background())
+	defer router.Close()
+	<-router.Running()
+
+	trades, _ := json.Marshal(&Response{Type: "trade", Data: []Data{{Symbol: "AAPL", Price: 182.52, Volume: 1, Timestamp: 1685629800123}}})
+	if err = bridge.Deliver(message.NewMessage(watermill.NewUUID(), trades)); err != nil {
+		t.Fatalf("expected the trades to be inserted, got %s", err)
+	}
+	if n := countTrades(t, db); n != 1 {
+		t.Fatalf("expected 1 trade to be inserted, got %d", n)
+	}
+
+	// A message that the handler can't process is nacked once rather than redelivered
+	done := make(chan error, 1)
+	go func() { done <- bridge.Deliver(message.NewMessage(watermill.NewUUID(), []byte("poison"))) }()
+
+	select {
+	case err = <-done:
+		if !errors.Is(err, ErrNacked) {
+			t.Errorf("expected the poison message to be nacked, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the poison message was not returned to the bridge")
+	}
+
+	bridge.Close()
+	if err = bridge.Deliver(message.NewMessage(watermill.NewUUID(), trades)); !errors.Is(err, ErrBridgeClosed) {
+		t.Errorf("expected delivering to a closed bridge to fail, got %v", err)
+	}
+}
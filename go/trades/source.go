@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TradeSource yields batches of normalized trades to be published to Ensign. Next
+// blocks until the next batch is available and returns io.EOF when the source is
+// exhausted; sources that stream forever (like Finnhub) never return io.EOF.
+type TradeSource interface {
+	Next() (*Response, error)
+	Close() error
+}
+
+// NewTradeSource creates the source by name: finnhub, file or synthetic
+func NewTradeSource(kind, path string, symbols []string, speed float64) (TradeSource, error) {
+	switch kind {
+	case "finnhub":
+		key := os.Getenv("FINNHUB_KEY")
+		if key == "" {
+			return nil, errors.New("Finnhub key is required: get one at https://finnhub.io/")
+		}
+		return NewFinnhubSource(key, symbols)
+	case "file":
+		return NewFileSource(path, speed)
+	case "synthetic":
+		return NewSyntheticSource(symbols, time.Second, 0.05, 0.2), nil
+	default:
+		return nil, fmt.Errorf("unknown trade source %q", kind)
+	}
+}
+
+//===========================================================================
+// Finnhub Websocket Source
+//===========================================================================
+
+// FinnhubSource reads trades from the Finnhub websocket
+type FinnhubSource struct {
+	conn *websocket.Conn
+}
+
+func NewFinnhubSource(key string, symbols []string) (_ *FinnhubSource, err error) {
+	// Get trades from Finnhub - FYI this Dialer dials the "Trades" endpoint
+	// see https://finnhub.io/docs/api/websocket-trades for more details
+	finnhub_url := fmt.Sprint("wss://ws.finnhub.io?token=", key)
+	src := &FinnhubSource{}
+	if src.conn, _, err = websocket.DefaultDialer.Dial(finnhub_url, nil); err != nil {
+		return nil, err
+	}
+
+	for _, s := range symbols {
+		msg, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "symbol": s})
+		if err = src.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			src.conn.Close()
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+func (s *FinnhubSource) Next() (*Response, error) {
+	// The Response struct is how we will boost the standard json marshalling library to know how to unpack and repackage Finnhub ticks
+	msg := &Response{}
+	if err := s.conn.ReadJSON(&msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *FinnhubSource) Close() error {
+	return s.conn.Close()
+}
+
+//===========================================================================
+// Historical File Source
+//===========================================================================
+
+// FileSource replays trades from a CSV or JSONL file, one trade per row or line. CSV
+// files must have a header with the columns symbol, price, volume, timestamp and
+// (optionally) conditions, where conditions are separated by semicolons. JSONL files
+// contain one Finnhub trade per line, e.g. {"s":"AAPL","p":189.1,"v":100,"t":1690000000000}.
+// If speed is greater than zero, the original spacing between trades is replayed at
+// that multiple of real time, otherwise the trades are replayed as fast as possible.
+type FileSource struct {
+	f     *os.File
+	speed float64
+	next  func() (Data, error)
+	last  uint64
+}
+
+func NewFileSource(path string, speed float64) (_ *FileSource, err error) {
+	src := &FileSource{speed: speed}
+	if src.f, err = os.Open(path); err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		err = src.readCSV()
+	case ".jsonl", ".json", ".ndjson":
+		src.readJSONL()
+	default:
+		err = fmt.Errorf("unknown trade file format %q: use .csv or .jsonl", filepath.Ext(path))
+	}
+
+	if err != nil {
+		src.f.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+func (s *FileSource) readCSV() (err error) {
+	reader := csv.NewReader(s.f)
+	reader.FieldsPerRecord = -1
+
+	var header []string
+	if header, err = reader.Read(); err != nil {
+		return fmt.Errorf("could not read csv header: %w", err)
+	}
+
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"symbol", "price", "timestamp"} {
+		if _, ok := cols[required]; !ok {
+			return fmt.Errorf("csv is missing required column %q", required)
+		}
+	}
+
+	s.next = func() (trade Data, err error) {
+		var row []string
+		if row, err = reader.Read(); err != nil {
+			return trade, err
+		}
+
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		trade.Symbol = field("symbol")
+		if trade.Price, err = strconv.ParseFloat(field("price"), 64); err != nil {
+			return trade, fmt.Errorf("could not parse price: %w", err)
+		}
+		if trade.Timestamp, err = strconv.ParseUint(field("timestamp"), 10, 64); err != nil {
+			return trade, fmt.Errorf("could not parse timestamp: %w", err)
+		}
+		if volume := field("volume"); volume != "" {
+			if trade.Volume, err = strconv.ParseFloat(volume, 64); err != nil {
+				return trade, fmt.Errorf("could not parse volume: %w", err)
+			}
+		}
+		if conditions := field("conditions"); conditions != "" {
+			trade.Conditions = strings.Split(conditions, ";")
+		}
+		return trade, nil
+	}
+	return nil
+}
+
+func (s *FileSource) readJSONL() {
+	scanner := bufio.NewScanner(s.f)
+	s.next = func() (trade Data, err error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			err = json.Unmarshal([]byte(line), &trade)
+			return trade, err
+		}
+
+		if err = scanner.Err(); err != nil {
+			return trade, err
+		}
+		return trade, io.EOF
+	}
+}
+
+func (s *FileSource) Next() (_ *Response, err error) {
+	var trade Data
+	if trade, err = s.next(); err != nil {
+		return nil, err
+	}
+
+	// Replay the original spacing between trades if requested
+	if s.speed > 0 && s.last > 0 && trade.Timestamp > s.last {
+		time.Sleep(time.Duration(float64(time.Duration(trade.Timestamp-s.last)*time.Millisecond) / s.speed))
+	}
+	s.last = trade.Timestamp
+	return &Response{Type: "trade", Data: []Data{trade}}, nil
+}
+
+func (s *FileSource) Close() error {
+	return s.f.Close()
+}
+
+//===========================================================================
+// Synthetic Source
+//===========================================================================
+
+// SyntheticSource generates trades for each symbol by simulating prices with geometric
+// Brownian motion so that the examples can be run without an API key. Drift and
+// volatility are annualized; every interval one trade is generated per symbol.
+type SyntheticSource struct {
+	interval   time.Duration
+	drift      float64
+	volatility float64
+	prices     map[string]float64
+	symbols    []string
+	rand       *rand.Rand
+	ticker     *time.Ticker
+}
+
+func NewSyntheticSource(symbols []string, interval time.Duration, drift, volatility float64) *SyntheticSource {
+	src := &SyntheticSource{
+		interval:   interval,
+		drift:      drift,
+		volatility: volatility,
+		prices:     make(map[string]float64, len(symbols)),
+		symbols:    symbols,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		ticker:     time.NewTicker(interval),
+	}
+
+	// Start each symbol at a random price between $10 and $500
+	for _, symbol := range symbols {
+		src.prices[symbol] = 10 + src.rand.Float64()*490
+	}
+	return src
+}
+
+func (s *SyntheticSource) Next() (*Response, error) {
+	ts := <-s.ticker.C
+
+	// The time step as a fraction of a trading year (252 days of 6.5 hours)
+	dt := s.interval.Hours() / (252 * 6.5)
+
+	msg := &Response{Type: "trade", Data: make([]Data, 0, len(s.symbols))}
+	for _, symbol := range s.symbols {
+		shock := s.rand.NormFloat64()
+		price := s.prices[symbol] * math.Exp((s.drift-0.5*s.volatility*s.volatility)*dt+s.volatility*math.Sqrt(dt)*shock)
+		s.prices[symbol] = price
+
+		msg.Data = append(msg.Data, Data{
+			Symbol:    symbol,
+			Price:     math.Round(price*100) / 100,
+			Volume:    float64(100 * (1 + s.rand.Intn(10))),
+			Timestamp: uint64(ts.UnixMilli()),
+		})
+	}
+	return msg, nil
+}
+
+func (s *SyntheticSource) Close() error {
+	s.ticker.Stop()
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/deadletter"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	ensign "github.com/rotationalio/go-ensign"
+)
+
+// Topic that fills and position updates are published to by the strategy runner
+const TradesOrders = "trades-orders"
+
+// Strategy receives trades and returns any orders that it would like to place. The same
+// strategy runs live against the Trades topic and in backtest mode against a replay
+// file. Strategies that would rather work with bars should implement BarStrategy.
+type Strategy interface {
+	OnTrade(trade Data, broker *SimBroker) []*Order
+}
+
+// BarStrategy receives completed bars instead of individual trades
+type BarStrategy interface {
+	OnBar(bar *Bar, broker *SimBroker) []*Order
+}
+
+// Bar is an OHLCV summary of the trades for a symbol over a fixed interval
+type Bar struct {
+	Symbol string
+	Start  time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// BarAggregator builds bars from trades, returning a bar when a trade arrives that
+// belongs to the next interval for its symbol.
+type BarAggregator struct {
+	Interval time.Duration
+	bars     map[string]*Bar
+}
+
+func NewBarAggregator(interval time.Duration) *BarAggregator {
+	return &BarAggregator{Interval: interval, bars: make(map[string]*Bar)}
+}
+
+func (a *BarAggregator) Add(trade Data) (completed *Bar) {
+	start := time.UnixMilli(int64(trade.Timestamp)).Truncate(a.Interval)
+	bar, ok := a.bars[trade.Symbol]
+	if ok && !start.After(bar.Start) {
+		bar.High = math.Max(bar.High, trade.Price)
+		bar.Low = math.Min(bar.Low, trade.Price)
+		bar.Close = trade.Price
+		bar.Volume += trade.Volume
+		return nil
+	}
+
+	a.bars[trade.Symbol] = &Bar{
+		Symbol: trade.Symbol,
+		Start:  start,
+		Open:   trade.Price,
+		High:   trade.Price,
+		Low:    trade.Price,
+		Close:  trade.Price,
+		Volume: trade.Volume,
+	}
+	return bar
+}
+
+// Flush returns the bars that are still in progress, sorted by symbol, so that the last
+// partial bar for each symbol isn't lost when the trades stop.
+func (a *BarAggregator) Flush() []*Bar {
+	bars := make([]*Bar, 0, len(a.bars))
+	for _, bar := range a.bars {
+		bars = append(bars, bar)
+	}
+	sort.Slice(bars, func(i, j int) bool { return bars[i].Symbol < bars[j].Symbol })
+
+	a.bars = make(map[string]*Bar)
+	return bars
+}
+
+// SMACross is an example strategy that goes long when the fast moving average of bar
+// closes crosses above the slow moving average and goes flat when it crosses below.
+type SMACross struct {
+	Fast     int
+	Slow     int
+	Quantity float64
+	closes   map[string][]float64
+}
+
+// NewSMACross returns an error unless 0 < fast < slow, since the fast average is taken
+// over the most recent closes in the slow window.
+func NewSMACross(fast, slow int, quantity float64) (*SMACross, error) {
+	if fast <= 0 || fast >= slow {
+		return nil, fmt.Errorf("the fast moving average must be over at least one bar and fewer bars than the slow one: -fast %d -slow %d", fast, slow)
+	}
+	return &SMACross{Fast: fast, Slow: slow, Quantity: quantity, closes: make(map[string][]float64)}, nil
+}
+
+// OnTrade is not used since SMACross works on bars, but satisfies the Strategy interface
+func (s *SMACross) OnTrade(trade Data, broker *SimBroker) []*Order {
+	return nil
+}
+
+func (s *SMACross) OnBar(bar *Bar, broker *SimBroker) []*Order {
+	closes := append(s.closes[bar.Symbol], bar.Close)
+	if len(closes) > s.Slow {
+		closes = closes[1:]
+	}
+	s.closes[bar.Symbol] = closes
+
+	if len(closes) < s.Slow {
+		return nil
+	}
+
+	fast, slow := average(closes[len(closes)-s.Fast:]), average(closes)
+	held := broker.Position(bar.Symbol).Quantity
+	switch {
+	case fast > slow && held == 0:
+		return []*Order{{Symbol: bar.Symbol, Side: Buy, Quantity: s.Quantity, Created: bar.Start}}
+	case fast < slow && held > 0:
+		return []*Order{{Symbol: bar.Symbol, Side: Sell, Quantity: held, Created: bar.Start}}
+	}
+	return nil
+}
+
+func average(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// StrategyRunner feeds trades to the strategy (aggregating them into bars if needed)
+// and submits the resulting orders to the simulated broker.
+type StrategyRunner struct {
+	Strategy Strategy
+	Broker   *SimBroker
+	Calendar *Calendar // if set, only trades in the regular session are used
+	bars     *BarAggregator
+}
+
+func (r *StrategyRunner) OnTrade(trade Data) {
+	// Pre-market and after-hours prints are thin and noisy so they can be skipped
+	if r.Calendar != nil && !r.Calendar.IsRegular(trade) {
+		return
+	}
+
+	// Fill any outstanding orders before the strategy sees the new trade
+	r.Broker.OnTrade(trade)
+
+	for _, order := range r.Strategy.OnTrade(trade, r.Broker) {
+		r.Broker.Submit(order)
+	}
+
+	if bs, ok := r.Strategy.(BarStrategy); ok && r.bars != nil {
+		if bar := r.bars.Add(trade); bar != nil {
+			for _, order := range bs.OnBar(bar, r.Broker) {
+				r.Broker.Submit(order)
+			}
+		}
+	}
+}
+
+// Close passes the final partial bars to the strategy once there are no more trades
+func (r *StrategyRunner) Close() {
+	if bs, ok := r.Strategy.(BarStrategy); ok && r.bars != nil {
+		for _, bar := range r.bars.Flush() {
+			for _, order := range bs.OnBar(bar, r.Broker) {
+				r.Broker.Submit(order)
+			}
+		}
+	}
+}
+
+// RunStrategy runs the example strategy either live against the Trades topic or as a
+// backtest against a replay file, printing a P&L and drawdown report at the end.
+func RunStrategy(args []string) {
+	fs := flag.NewFlagSet("strategy", flag.ExitOnError)
+	mode := fs.String("mode", "backtest", "run the strategy live against the trades topic or backtest against a replay file")
+	path := fs.String("path", "", "path to a csv or jsonl replay file for backtest mode")
+	publish := fs.Bool("publish", false, "publish Fill and PositionUpdate events (always on in live mode)")
+	cash := fs.Float64("cash", 100000, "starting cash for the simulated broker")
+	slippage := fs.Float64("slippage", 1, "slippage in basis points applied against each fill")
+	feeBps := fs.Float64("fee-bps", 0.5, "fees in basis points of the notional value of each fill")
+	feePerOrder := fs.Float64("fee-per-order", 1, "flat fee charged for each fill")
+	barInterval := fs.Duration("bar", time.Minute, "interval of the bars passed to the strategy")
+	fast := fs.Int("fast", 5, "number of bars in the fast moving average")
+	slow := fs.Int("slow", 20, "number of bars in the slow moving average")
+	quantity := fs.Float64("quantity", 100, "number of shares to buy on each entry")
+	regularOnly := fs.Bool("regular-only", false, "only use trades from the regular market session")
+	calendarPath := fs.String("calendar", "", "path to an updated holiday calendar, by default the embedded calendar is used")
+	creds := config.RegisterFlags(fs)
+	logs := logger.RegisterFlags(fs)
+	prom := metrics.RegisterFlags(fs)   // -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	traces := tracing.RegisterFlags(fs) // -trace-exporter otlp or file or $ENSIGN_TRACE_EXPORTER
+	fs.Parse(args)
+	log := logs.Setup()
+	stats := prom.Setup()
+	defer traces.Setup("trades-strategy")()
+
+	strategy, err := NewSMACross(*fast, *slow, *quantity)
+	if err != nil {
+		logger.Fatal("could not create strategy", err)
+	}
+
+	broker := NewSimBroker(*cash, *slippage, *feeBps, *feePerOrder)
+	runner := &StrategyRunner{
+		Strategy: strategy,
+		Broker:   broker,
+		bars:     NewBarAggregator(*barInterval),
+	}
+
+	if *regularOnly {
+		if runner.Calendar, err = LoadCalendar(*calendarPath); err != nil {
+			logger.Fatal("could not load market calendar", err)
+		}
+	}
+
+	var client *ensign.Client
+	if *mode == "live" || *publish {
+		if client, err = creds.Client(); err != nil {
+			logger.Fatal("could not create client", err)
+		}
+		EnsureTopic(client, TradesOrders)
+		broker.OnFill = func(fill *Fill, update *PositionUpdate) {
+			if err := publishJSON(context.Background(), client, stats, TradesOrders, fill); err != nil {
+				log.Error("could not publish fill", logger.KeyTopic, TradesOrders, "error", err)
+			}
+			if err := publishJSON(context.Background(), client, stats, TradesOrders, update); err != nil {
+				log.Error("could not publish position update", logger.KeyTopic, TradesOrders, "error", err)
+			}
+		}
+	}
+
+	switch *mode {
+	case "backtest":
+		source, err := NewFileSource(*path, 0)
+		if err != nil {
+			logger.Fatal("could not open backtest trades", err, "path", *path)
+		}
+		defer source.Close()
+
+		for {
+			msg, err := source.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				logger.Fatal("could not read backtest trades", err, "path", *path)
+			}
+
+			for _, trade := range msg.Data {
+				runner.OnTrade(trade)
+			}
+		}
+
+	case "live":
+		EnsureTopic(client, Trades)
+		sub, err := client.Subscribe(Trades)
+		if err != nil {
+			logger.Fatal("could not create subscriber", err, logger.KeyTopic, Trades)
+		}
+		defer sub.Close()
+
+		dlq := &deadletter.Consumer{Client: client, Topic: Trades}
+		events := TradesMux(dlq, stats, func(ctx context.Context, event *ensign.Event, msg *Response) error {
+			for _, trade := range msg.Data {
+				runner.OnTrade(trade)
+			}
+			return nil
+		})
+
+		// Run until interrupted, then print the report
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if err = events.Serve(ctx, sub); err == nil {
+			log.Warn("subscription closed", logger.KeyTopic, Trades)
+		}
+
+	default:
+		logger.Fatal("could not run strategy", fmt.Errorf("unknown strategy mode %q: use live or backtest", *mode))
+	}
+
+	runner.Close()
+	broker.Report()
+}
+
+// publishJSON encodes the value as JSON and publishes it as an event of the type
+// registered for the value, continuing the trace in the context
+func publishJSON(ctx context.Context, client *ensign.Client, stats *metrics.Metrics, topic string, v interface{}) (err error) {
+	e := &ensign.Event{}
+	if err = types.Encode(codec.JSON, e, v); err != nil {
+		return err
+	}
+	return publish(ctx, client, stats, topic, e)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSMACross(t *testing.T) {
+	testCases := []struct {
+		fast, slow int
+		valid      bool
+	}{
+		{5, 20, true},
+		{1, 2, true},
+		{0, 20, false},
+		{-1, 20, false},
+		{20, 20, false},
+		{30, 20, false},
+	}
+
+	for _, tc := range testCases {
+		_, err := NewSMACross(tc.fast, tc.slow, 100)
+		if tc.valid && err != nil {
+			t.Errorf("-fast %d -slow %d: unexpected error %s", tc.fast, tc.slow, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("-fast %d -slow %d: expected an error", tc.fast, tc.slow)
+		}
+	}
+}
+
+// recordBars is a bar strategy that remembers the bars it was given
+type recordBars struct {
+	bars []*Bar
+}
+
+func (r *recordBars) OnTrade(trade Data, broker *SimBroker) []*Order { return nil }
+
+func (r *recordBars) OnBar(bar *Bar, broker *SimBroker) []*Order {
+	r.bars = append(r.bars, bar)
+	return nil
+}
+
+func TestStrategyRunnerClose(t *testing.T) {
+	strategy := &recordBars{}
+	runner := &StrategyRunner{
+		Strategy: strategy,
+		Broker:   NewSimBroker(100000, 0, 0, 0),
+		bars:     NewBarAggregator(time.Minute),
+	}
+
+	start := time.Date(2023, 6, 1, 14, 30, 0, 0, time.UTC)
+	for i, price := range []float64{10, 11, 12} {
+		ts := start.Add(time.Duration(i) * 40 * time.Second)
+		runner.OnTrade(Data{Symbol: "AAPL", Price: price, Volume: 1, Timestamp: uint64(ts.UnixMilli())})
+	}
+
+	// The trade in the second minute completes the first bar
+	if len(strategy.bars) != 1 || strategy.bars[0].Close != 11 || strategy.bars[0].Volume != 2 {
+		t.Fatalf("expected the first bar to be completed, got %+v", strategy.bars)
+	}
+
+	runner.Close()
+	if len(strategy.bars) != 2 {
+		t.Fatalf("expected the partial bar to be passed to the strategy on close, got %d bars", len(strategy.bars))
+	}
+
+	last := strategy.bars[1]
+	if last.Open != 12 || last.Close != 12 || last.Volume != 1 || !last.Start.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected final bar %+v", last)
+	}
+
+	runner.Close()
+	if len(strategy.bars) != 2 {
+		t.Errorf("expected close to only flush the partial bars once")
+	}
+}
@@ -1,15 +1,14 @@
 package main
 
 import (
-	"bytes"
 	stdSQL "database/sql"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
 )
 
 type WeatherInfo struct {
@@ -23,6 +22,7 @@ type WeatherInfo struct {
 	Visibility    float64
 	Precipitation float64
 	CreatedAt     string
+	TraceID       string // the trace that followed the reading from the API poll
 }
 
 type postgresSchemaAdapter struct{}
@@ -34,17 +34,16 @@ func (p postgresSchemaAdapter) SchemaInitializingQueries(topic string) []string
 
 func (p postgresSchemaAdapter) InsertQuery(topic string, msgs message.Messages) (string, []interface{}, error) {
 	insertQuery := fmt.Sprintf(
-		`INSERT INTO %s (last_updated, temperature, feels_like, humidity, condition, wind_mph, wind_direction, visibility, precipitation, created_at) VALUES %s`,
+		`INSERT INTO %s (last_updated, temperature, feels_like, humidity, condition, wind_mph, wind_direction, visibility, precipitation, created_at, trace_id) VALUES %s`,
 		topic,
-		strings.TrimRight(strings.Repeat(`($1,$2,$3,$4,$5,$6,$7,$8,$9,$10),`, len(msgs)), ","),
+		strings.TrimRight(strings.Repeat(`($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11),`, len(msgs)), ","),
 	)
 
 	var args []interface{}
 	for _, msg := range msgs {
 		weatherInfo := WeatherInfo{}
 
-		decoder := gob.NewDecoder(bytes.NewBuffer(msg.Payload))
-		err := decoder.Decode(&weatherInfo)
+		err := codec.Gob.Unmarshal(msg.Payload, &weatherInfo)
 		if err != nil {
 			return "", nil, err
 		}
@@ -61,6 +60,7 @@ func (p postgresSchemaAdapter) InsertQuery(topic string, msgs message.Messages)
 			weatherInfo.Visibility,
 			weatherInfo.Precipitation,
 			weatherInfo.CreatedAt,
+			weatherInfo.TraceID,
 		)
 	}
 
@@ -1,28 +1,36 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	stdSQL "database/sql"
-	"encoding/gob"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	sdk "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
+	mimetype "github.com/rotationalio/go-ensign/mimetype/v1beta1"
 	"github.com/rotationalio/watermill-ensign/pkg/ensign"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-sql/pkg/sql"
+	wmmetrics "github.com/ThreeDotsLabs/watermill/components/metrics"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/ThreeDotsLabs/watermill/message/router/plugin"
 )
 
 var (
-	logger = watermill.NewStdLogger(false, false)
 	//topic the subscriber is listening to
 	weather_api_topic = "current_weather"
 	//weather_info is the topic to which the subscriber posts messages to be inserted into the database
@@ -41,21 +49,55 @@ type ApiWeatherInfo struct {
 	Precipitation float64
 }
 
+// WeatherInfoType is the event type published by the producer; readings published with
+// a different major version are refused instead of being misread
+var WeatherInfoType = types.MustRegister("ApiWeatherInfo", "1.0.0", ApiWeatherInfo{}, "")
+
 func main() {
-	router, err := message.NewRouter(message.RouterConfig{}, logger)
+	// Pick the credentials with -credentials path/to/key.json or -profile dev
+	flags := config.RegisterFlags(flag.CommandLine)
+	// Pick the log output with -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	logs := logger.RegisterFlags(flag.CommandLine)
+	// Serve Prometheus metrics with -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	prom := metrics.RegisterFlags(flag.CommandLine)
+	// Export a trace of every reading with -trace-exporter otlp (or file) or $ENSIGN_TRACE_EXPORTER
+	traces := tracing.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// the router, subscriber and publisher log to the same output through the adapter
+	log := logs.Setup()
+	wmLogger := logger.NewWatermill(log)
+	stats := prom.Setup()
+	defer traces.Setup("weather-consumer")()
+
+	creds, err := flags.Load()
+	if err != nil {
+		logger.Fatal("could not load Ensign credentials", err)
+	}
+	log.Info("loaded Ensign credentials", "source", creds.Source)
+
+	router, err := message.NewRouter(message.RouterConfig{}, wmLogger)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create router", err)
 	}
 
 	//SignalsHandler will gracefully shutdown Router when SIGTERM is received
 	router.AddPlugin(plugin.SignalsHandler)
 	//The Recoverer middleware handles panics from handlers
 	router.AddMiddleware(middleware.Recoverer)
+	//Count the messages received, acked and nacked by the handler, time the handler and
+	//the postgres publisher, and serve them with the rest of the metrics
+	wmmetrics.NewPrometheusMetricsBuilder(stats.Registry, metrics.Namespace, "watermill").AddPrometheusRouterMetrics(router)
+	//Continue the trace from the producer in the handler and record a span for each insert
+	router.AddMiddleware(tracing.WatermillMiddleware)
+	router.AddPublisherDecorators(tracing.DecoratePublisher)
+	//The CorrelationID middleware copies the producer's correlation ID to the inserted messages
+	router.AddMiddleware(middleware.CorrelationID)
 
 	postgresDB := createPostgresConnection()
-	log.Println("added postgres connection and created weather_info table")
-	subscriber := createSubscriber(logger)
-	publisher := createPublisher(postgresDB)
+	log.Info("added postgres connection and created weather_info table")
+	subscriber := createSubscriber(creds, wmLogger)
+	publisher := createPublisher(postgresDB, wmLogger)
 
 	router.AddHandler(
 		"weather_info_inserter",
@@ -67,7 +109,7 @@ func main() {
 	)
 
 	if err = router.Run(context.Background()); err != nil {
-		panic(err)
+		logger.Fatal("router stopped", err)
 	}
 }
 
@@ -81,12 +123,12 @@ func createPostgresConnection() *stdSQL.DB {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
 	db, err := stdSQL.Open("postgres", dsn)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not open postgres connection", err)
 	}
 
 	err = db.Ping()
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not connect to postgres", err, "host", host, "dbname", dbname)
 	}
 
 	createQuery := `CREATE TABLE IF NOT EXISTS weather_info (
@@ -100,42 +142,50 @@ func createPostgresConnection() *stdSQL.DB {
 		wind_direction VARCHAR(36),
 		visibility DECIMAL,
 		precipitation DECIMAL,
-		created_at VARCHAR(100) NOT NULL
+		created_at VARCHAR(100) NOT NULL,
+		trace_id VARCHAR(32)
 	);`
 	_, err = db.ExecContext(context.Background(), createQuery)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create weather_info table", err)
+	}
+
+	//tables created before readings were traced don't have the trace_id column
+	_, err = db.ExecContext(context.Background(), `ALTER TABLE weather_info ADD COLUMN IF NOT EXISTS trace_id VARCHAR(32);`)
+	if err != nil {
+		logger.Fatal("could not add trace_id to the weather_info table", err)
 	}
 
 	return db
 }
 
-func createSubscriber(logger watermill.LoggerAdapter) message.Subscriber {
+func createSubscriber(creds *config.Credentials, wmLogger watermill.LoggerAdapter) message.Subscriber {
 	subscriber, err := ensign.NewSubscriber(
 		ensign.SubscriberConfig{
+			EnsignConfig:      &sdk.Options{ClientID: creds.ClientID, ClientSecret: creds.ClientSecret},
 			EnsureCreateTopic: true,
-			Unmarshaler:       ensign.EventMarshaler{},
+			Unmarshaler:       metadataUnmarshaler{},
 		},
-		logger,
+		wmLogger,
 	)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create subscriber", err)
 	}
 
 	return subscriber
 }
 
-func createPublisher(db *stdSQL.DB) message.Publisher {
+func createPublisher(db *stdSQL.DB, wmLogger watermill.LoggerAdapter) message.Publisher {
 	pub, err := sql.NewPublisher(
 		db,
 		sql.PublisherConfig{
 			SchemaAdapter:        postgresSchemaAdapter{},
 			AutoInitializeSchema: false, //false because the table has already been created
 		},
-		logger,
+		wmLogger,
 	)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create postgres publisher", err)
 	}
 
 	return pub
@@ -147,24 +197,36 @@ type dbHandler struct {
 
 func (d dbHandler) checkRecordExists(msg *message.Message) ([]*message.Message, error) {
 	weatherInfo := ApiWeatherInfo{}
+	traceID := tracing.TraceID(msg.Context())
+	log := slog.With(logger.KeyTopic, weather_api_topic, logger.KeyEventID, msg.UUID, logger.KeyEventType, msg.Metadata.Get(ensign.TypeNameKey), tracing.KeyTraceID, traceID, middleware.CorrelationIDMetadataKey, middleware.MessageCorrelationID(msg))
 
-	err := json.Unmarshal(msg.Payload, &weatherInfo)
+	//check that the reading was published with a compatible version of the event type
+	if err := checkReading(msg); err != nil {
+		return nil, err
+	}
+
+	//decode the payload with the codec for the mimetype of the Ensign event
+	enc, err := messageCodec(msg)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("received weather info: %+v", weatherInfo)
+	if err = enc.Unmarshal(msg.Payload, &weatherInfo); err != nil {
+		return nil, err
+	}
+
+	log.Debug("received weather info", "weather", weatherInfo)
 
 	var count int
 	query := "SELECT count(*) FROM weather_info WHERE last_updated = $1"
 
-	err = d.db.QueryRow(query, weatherInfo.LastUpdated).Scan(&count)
+	err = d.db.QueryRowContext(msg.Context(), query, weatherInfo.LastUpdated).Scan(&count)
 	switch {
 	case err != nil:
 		return nil, err
 	default:
 		if count > 0 {
-			log.Println("Found existing record in the database")
+			log.Info("found existing record in the database", "last_updated", weatherInfo.LastUpdated)
 			// not throwing an error here because this is not an issue
 			return nil, nil
 		}
@@ -179,17 +241,76 @@ func (d dbHandler) checkRecordExists(msg *message.Message) ([]*message.Message,
 			Visibility:    weatherInfo.Visibility,
 			Precipitation: weatherInfo.Precipitation,
 			CreatedAt:     time.Now().String(),
+			TraceID:       traceID,
 		}
-		log.Println(newWeatherInfo)
+		log.Info("inserting weather info", "last_updated", newWeatherInfo.LastUpdated, "created_at", newWeatherInfo.CreatedAt)
 		//encode the weather data
-		var payload bytes.Buffer
-		encoder := gob.NewEncoder(&payload)
-		err := encoder.Encode(newWeatherInfo)
+		payload, err := codec.Gob.Marshal(newWeatherInfo)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		//construct a watermill message
-		newMessage := message.NewMessage(watermill.NewUUID(), payload.Bytes())
+		newMessage := message.NewMessage(watermill.NewUUID(), payload)
+		newMessage.Metadata.Set(ensign.MIMEKey, codec.Gob.Mimetype().MimeType())
 		return []*message.Message{newMessage}, nil
 	}
 }
+
+// metadataUnmarshaler copies the Ensign event metadata, i.e. the trace context and the
+// correlation ID set by the producer, into the message metadata; the EventMarshaler only
+// copies the Ensign fields.
+type metadataUnmarshaler struct {
+	ensign.EventMarshaler
+}
+
+func (m metadataUnmarshaler) Unmarshal(event *api.Event) (msg *message.Message, err error) {
+	if msg, err = m.EventMarshaler.Unmarshal(event); err != nil {
+		return nil, err
+	}
+
+	for key, value := range event.Metadata {
+		if msg.Metadata.Get(key) == "" {
+			msg.Metadata.Set(key, value)
+		}
+	}
+	return msg, nil
+}
+
+// messageCodec returns the codec for the mimetype that watermill-ensign copies from the
+// Ensign event into the message metadata, assuming JSON if the mimetype is missing.
+func messageCodec(msg *message.Message) (codec.Codec, error) {
+	value := msg.Metadata.Get(ensign.MIMEKey)
+	if value == "" {
+		return codec.JSON, nil
+	}
+
+	mime, err := mimetype.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Default.Lookup(mime)
+}
+
+// checkReading returns nil if the reading can be decoded as the WeatherInfoType. The
+// producer published readings without an event type before it registered one, so an
+// untyped reading is the v1 payload rather than a mismatch.
+func checkReading(msg *message.Message) error {
+	incoming := messageType(msg)
+	if incoming == nil {
+		incoming = &api.Type{Name: WeatherInfoType.Name, MajorVersion: 1}
+	}
+	return WeatherInfoType.Check(incoming)
+}
+
+// messageType returns the event type that watermill-ensign copies from the Ensign event
+// into the message metadata, or nil if the event had no type. Only the major version is
+// carried by watermill-ensign so the minor and patch versions are zero.
+func messageType(msg *message.Message) *api.Type {
+	name := msg.Metadata.Get(ensign.TypeNameKey)
+	if name == "" {
+		return nil
+	}
+
+	major, _ := strconv.ParseUint(msg.Metadata.Get(ensign.TypeVersionKey), 10, 32)
+	return &api.Type{Name: name, MajorVersion: uint32(major)}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	"github.com/rotationalio/watermill-ensign/pkg/ensign"
+)
+
+// reading returns a message like the ones watermill-ensign delivers for a reading, with
+// the event type in the metadata unless the name is empty.
+func reading(payload, name, major string) *message.Message {
+	msg := message.NewMessage(watermill.NewUUID(), []byte(payload))
+	if name != "" {
+		msg.Metadata.Set(ensign.TypeNameKey, name)
+		msg.Metadata.Set(ensign.TypeVersionKey, major)
+	}
+	return msg
+}
+
+func TestCheckReading(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  *message.Message
+		err  error
+	}{
+		{"untyped", reading(`{}`, "", ""), nil},
+		{"v1", reading(`{}`, "ApiWeatherInfo", "1"), nil},
+		{"v2", reading(`{}`, "ApiWeatherInfo", "2"), types.ErrIncompatible},
+		{"other type", reading(`{}`, "Forecast", "1"), types.ErrTypeMismatch},
+	}
+
+	for _, tc := range testCases {
+		err := checkReading(tc.msg)
+		if tc.err == nil && err != nil {
+			t.Errorf("%s: expected the reading to be accepted, got %s", tc.name, err)
+		}
+		if tc.err != nil && !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestLegacyReading(t *testing.T) {
+	// Readings from the producer before it registered the event type have no type or
+	// mimetype and are decoded as the JSON v1 payload
+	msg := reading(`{"LastUpdated":"2023-06-01 09:00","Temperature":71.5,"Humidity":40,"Condition":"Sunny"}`, "", "")
+	if err := checkReading(msg); err != nil {
+		t.Fatalf("expected an untyped reading to be accepted, got %s", err)
+	}
+
+	enc, err := messageCodec(msg)
+	if err != nil {
+		t.Fatalf("could not get the codec for an untyped reading: %s", err)
+	}
+
+	var info ApiWeatherInfo
+	if err = enc.Unmarshal(msg.Payload, &info); err != nil {
+		t.Fatalf("could not decode the legacy reading: %s", err)
+	}
+
+	if info.LastUpdated != "2023-06-01 09:00" || info.Temperature != 71.5 || info.Humidity != 40 || info.Condition != "Sunny" {
+		t.Errorf("unexpected legacy reading %+v", info)
+	}
+}
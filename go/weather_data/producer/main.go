@@ -1,44 +1,87 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
+	"github.com/rotationalio/ensign-examples/go/shared/codec"
+	"github.com/rotationalio/ensign-examples/go/shared/config"
+	"github.com/rotationalio/ensign-examples/go/shared/logger"
+	"github.com/rotationalio/ensign-examples/go/shared/metrics"
+	"github.com/rotationalio/ensign-examples/go/shared/tracing"
+	"github.com/rotationalio/ensign-examples/go/shared/types"
+	sdk "github.com/rotationalio/go-ensign"
+	api "github.com/rotationalio/go-ensign/api/v1beta1"
 	"github.com/rotationalio/watermill-ensign/pkg/ensign"
 
 	"github.com/ThreeDotsLabs/watermill"
+	wmmetrics "github.com/ThreeDotsLabs/watermill/components/metrics"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 )
 
 func main() {
-	// add a logger
-	logger := watermill.NewStdLogger(false, false)
-	logger.Info("Starting the producer", watermill.LogFields{})
+	// Pick the credentials with -credentials path/to/key.json or -profile dev
+	flags := config.RegisterFlags(flag.CommandLine)
+	// Pick the log output with -log-format json -log-level debug or $ENSIGN_LOG_FORMAT and $ENSIGN_LOG_LEVEL
+	logs := logger.RegisterFlags(flag.CommandLine)
+	// Serve Prometheus metrics with -metrics-addr :2112 or $ENSIGN_METRICS_ADDR
+	prom := metrics.RegisterFlags(flag.CommandLine)
+	// Export a trace of every reading with -trace-exporter otlp (or file) or $ENSIGN_TRACE_EXPORTER
+	traces := tracing.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	// add a logger, watermill logs to the same output through the adapter
+	log := logs.Setup()
+	log.Info("starting the producer")
+	stats := prom.Setup()
+	defer traces.Setup("weather-producer")()
+
+	creds, err := flags.Load()
+	if err != nil {
+		logger.Fatal("could not load Ensign credentials", err)
+	}
+	log.Info("loaded Ensign credentials", "source", creds.Source)
 
 	//create the publisher
 	publisher, err := ensign.NewPublisher(
 		ensign.PublisherConfig{
+			EnsignConfig:      &sdk.Options{ClientID: creds.ClientID, ClientSecret: creds.ClientSecret},
 			EnsureCreateTopic: true,
-			Marshaler:         ensign.EventMarshaler{},
+			Marshaler:         metadataMarshaler{},
 		},
-		logger,
+		logger.NewWatermill(log),
 	)
 	if err != nil {
-		panic(err)
+		logger.Fatal("could not create publisher", err)
 	}
 	defer publisher.Close()
 
+	//time every publish to Ensign with watermill's publisher metrics
+	measured, err := wmmetrics.NewPrometheusMetricsBuilder(stats.Registry, metrics.Namespace, "watermill").DecoratePublisher(publisher)
+	if err != nil {
+		logger.Fatal("could not add publisher metrics", err)
+	}
+
+	//record a span for every publish and inject it into the message metadata
+	traced, err := tracing.DecoratePublisher(measured)
+	if err != nil {
+		logger.Fatal("could not add publisher tracing", err)
+	}
+
 	//used to signal the publisher to stop publishing
 	closeCh := make(chan struct{})
 
-	go publishWeatherData(publisher, closeCh)
+	go publishWeatherData(traced, closeCh)
 
 	// wait for SIGINT - this will end processing
 	c := make(chan os.Signal, 1)
@@ -48,7 +91,7 @@ func main() {
 	// signal for the publisher to stop publishing
 	close(closeCh)
 
-	logger.Info("All messages published", nil)
+	log.Info("all messages published")
 }
 
 func publishWeatherData(publisher message.Publisher, closeCh chan struct{}) {
@@ -63,39 +106,75 @@ func publishWeatherData(publisher message.Publisher, closeCh chan struct{}) {
 		case <-ticker.C:
 		}
 
+		//every reading starts a trace that follows it from the API poll to the Postgres row
+		ctx, span := tracing.Tracer().Start(context.Background(), "poll current weather")
+
 		//call the Weather API to get the weather data
 		weatherData, err := GetCurrentWeather()
 		if err != nil {
-			log.Println("Issue retrieving weather data: ", err)
+			slog.Error("could not retrieve weather data", "error", err)
+			tracing.End(span, err)
 			continue
 		}
 
-		//marshall the weather data
-		payload, err := json.Marshal(weatherData)
+		//encode the weather data
+		payload, err := codec.JSON.Marshal(weatherData)
 		if err != nil {
-			log.Println("Could not marshall weatherData: ", err)
+			slog.Error("could not marshal weather data", "error", err)
+			tracing.End(span, err)
 			continue
 		}
 
-		//construct a watermill message
+		//construct a watermill message, the mimetype and event type in the metadata are
+		//set on the Ensign event so that the consumer knows how to decode the payload;
+		//watermill-ensign only carries the major version of the event type
 		msg := message.NewMessage(watermill.NewUUID(), payload)
+		msg.Metadata.Set(ensign.MIMEKey, codec.JSON.Mimetype().MimeType())
+		msg.Metadata.Set(ensign.TypeNameKey, WeatherInfoType.Name)
+		msg.Metadata.Set(ensign.TypeVersionKey, strconv.FormatUint(uint64(WeatherInfoType.Version.Major), 10))
 
 		//Use a middleware to set the correlation ID, it's useful for debugging
 		middleware.SetCorrelationID(watermill.NewShortUUID(), msg)
 
+		//the tracing publisher starts the publish span from the message context
+		msg.SetContext(ctx)
+
 		//publish the message to the "current weather" topic
+		log := slog.With(logger.KeyTopic, "current_weather", logger.KeyEventID, msg.UUID, logger.KeyEventType, WeatherInfoType.String(), tracing.KeyTraceID, tracing.TraceID(ctx))
 		err = publisher.Publish("current_weather", msg)
+		tracing.End(span, err)
 		if err != nil {
-			log.Println("cannot publish message: ", err)
+			log.Error("could not publish message", "error", err)
 			continue
 		}
+		log.Debug("published weather reading", "last_updated", weatherData.LastUpdated, middleware.CorrelationIDMetadataKey, middleware.MessageCorrelationID(msg))
 	}
 }
 
+// metadataMarshaler carries the trace context and the correlation ID in the metadata of
+// the Ensign event; the EventMarshaler only maps the Ensign fields and drops the rest of
+// the message metadata, which would break the trace at the Ensign hop.
+type metadataMarshaler struct {
+	ensign.EventMarshaler
+}
+
+func (m metadataMarshaler) Marshal(topic string, msg *message.Message) (event *api.Event, err error) {
+	if event, err = m.EventMarshaler.Marshal(topic, msg); err != nil {
+		return nil, err
+	}
+
+	event.Metadata = make(map[string]string)
+	for _, key := range append(tracing.Fields(), middleware.CorrelationIDMetadataKey) {
+		if value := msg.Metadata.Get(key); value != "" {
+			event.Metadata[key] = value
+		}
+	}
+	return event, nil
+}
+
 func GetCurrentWeather() (ApiWeatherInfo, error) {
 	req, err := http.NewRequest("GET", "http://api.weatherapi.com/v1/current.json?", nil)
 	if err != nil {
-		log.Println(err)
 		return ApiWeatherInfo{}, err
 	}
 
@@ -112,7 +191,7 @@ func GetCurrentWeather() (ApiWeatherInfo, error) {
 	}
 	defer resp.Body.Close()
 
-	log.Println("Response Status: ", resp.Status)
+	slog.Debug("weather API responded", "status", resp.Status)
 	if resp.StatusCode != 200 {
 		return ApiWeatherInfo{}, errors.New("did not receive 200 response code")
 	}
@@ -170,3 +249,7 @@ type ApiWeatherInfo struct {
 	Visibility    float64
 	Precipitation float64
 }
+
+// WeatherInfoType is the event type of the published weather readings, the consumer
+// declares the same type and refuses readings with a different major version
+var WeatherInfoType = types.MustRegister("ApiWeatherInfo", "1.0.0", ApiWeatherInfo{}, "")